@@ -1,27 +1,36 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
 )
 
 type bomLine struct {
-	IPN          ipn     `csv:"IPN" yaml:"ipn"`
-	Qty          float64 `csv:"Qty" yaml:"qty"`
-	MPN          string  `csv:"MPN" yaml:"mpn"`
-	Manufacturer string  `csv:"Manufacturer" yaml:"manufacturer"`
-	Ref          string  `csv:"Ref" yaml:"ref"`
-	Value        string  `csv:"Value" yaml:"value"`
-	CmpName      string  `csv:"Cmp name" yaml:"cmpName"`
-	Footprint    string  `csv:"Footprint" yaml:"footprint"`
-	Description  string  `csv:"Description" yaml:"description"`
-	Vendor       string  `csv:"Vendor" yaml:"vendor"`
-	Datasheet    string  `csv:"Datasheet" yaml:"datasheet"`
-	Checked      string  `csv:"Checked" yaml:"checked"`
+	IPN          ipn     `csv:"IPN" yaml:"ipn" json:"ipn"`
+	Qty          float64 `csv:"Qty" yaml:"qty" json:"qty"`
+	MPN          string  `csv:"MPN" yaml:"mpn" json:"mpn"`
+	Manufacturer string  `csv:"Manufacturer" yaml:"manufacturer" json:"manufacturer"`
+	Ref          string  `csv:"Ref" yaml:"ref" json:"ref"`
+	Value        string  `csv:"Value" yaml:"value" json:"value"`
+	CmpName      string  `csv:"Cmp name" yaml:"cmpName" json:"cmpName"`
+	Footprint    string  `csv:"Footprint" yaml:"footprint" json:"footprint"`
+	Description  string  `csv:"Description" yaml:"description" json:"description"`
+	Vendor       string  `csv:"Vendor" yaml:"vendor" json:"vendor"`
+	Datasheet    string  `csv:"Datasheet" yaml:"datasheet" json:"datasheet"`
+	Checked      string  `csv:"Checked" yaml:"checked" json:"checked"`
+	// Extra holds input columns that are not listed above, and partmaster
+	// columns carried into release BOMs
+	Extra csvExtra `csv:"-" yaml:"-" json:"extra,omitempty"`
 }
 
 func (bl *bomLine) String() string {
@@ -40,14 +49,21 @@ func (bl *bomLine) String() string {
 		bl.Checked)
 }
 
+// parseRefs splits a list of reference designators. Refs may be delimited by
+// spaces or commas.
+func parseRefs(refs string) []string {
+	return strings.FieldsFunc(refs, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// removeRef removes one or more refs from a line and updates the qty. Lines
+// that do not contain any of the refs are not modified.
 func (bl *bomLine) removeRef(ref string) {
-	refs := strings.Split(bl.Ref, " ")
-	refsOut := []string{}
-	for _, r := range refs {
-		r = strings.Trim(r, " ")
-		if r != ref && r != "" {
-			refsOut = append(refsOut, r)
-		}
+	refs := parseRefs(bl.Ref)
+	refsOut := lo.Without(refs, parseRefs(ref)...)
+	if len(refsOut) == len(refs) {
+		return
 	}
 	bl.Ref = strings.Join(refsOut, " ")
 	bl.Qty = float64(len(refsOut))
@@ -106,7 +122,9 @@ func (b bom) String() string {
 }
 
 // merge can be used to merge partmaster attributes into a BOM
-func (b *bom) mergePartmaster(p partmaster, logErr func(string)) {
+// mergePartmaster copies part info from the partmaster into the BOM. The
+// extra partmaster columns listed in columns are also copied.
+func (b *bom) mergePartmaster(p partmaster, columns []string, logErr func(string)) {
 	// populate MPN info in our BOM
 	for i, l := range *b {
 		pmPart, err := p.findPart(l.IPN)
@@ -119,7 +137,67 @@ func (b *bom) mergePartmaster(p partmaster, logErr func(string)) {
 		l.Datasheet = pmPart.Datasheet
 		l.Checked = pmPart.Checked
 		l.Description = pmPart.Description
+		for _, c := range columns {
+			v, _ := pmPart.Extra.get(c)
+			l.Extra.set(c, v)
+		}
+	}
+}
+
+// findBomReferences returns the source BOMs and release scripts in the
+// workspace that use an IPN. The workspace index is rebuilt so recently added
+// BOMs are found. Files that can not be loaded are logged and skipped, unless
+// they contain the IPN.
+func findBomReferences(pn ipn, templatesDir string) ([]string, error) {
+	invalidateWorkspace()
+	ws, err := getWorkspace()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, p := range ws.sourceBoms() {
+		ipns, err := bomFileIPNs(p, templatesDir)
+		if err != nil {
+			log.Printf("Error loading %v while looking for references to %v: %v", p, pn, err)
+			// be safe and treat a mention of the IPN as a reference
+			if data, err := os.ReadFile(p); err == nil && bytes.Contains(data, []byte(pn)) {
+				refs = append(refs, p)
+			}
+			continue
+		}
+
+		if lo.Contains(ipns, pn) {
+			refs = append(refs, p)
+		}
+	}
+
+	return refs, nil
+}
+
+// bomFileIPNs returns the IPNs used in a source BOM or added by a release
+// script
+func bomFileIPNs(p, templatesDir string) ([]ipn, error) {
+	var ipns []ipn
+	switch filepath.Ext(p) {
+	case ".csv":
+		b := bom{}
+		if err := loadCSV(p, &b); err != nil {
+			return nil, err
+		}
+		for _, l := range b {
+			ipns = append(ipns, l.IPN)
+		}
+	case ".yml":
+		rs, err := loadRelScript(p, templatesDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range rs.Add {
+			ipns = append(ipns, l.IPN)
+		}
 	}
+	return ipns, nil
 }
 
 func (b *bom) copy() bom {
@@ -136,7 +214,7 @@ func (b *bom) processOurIPN(pn ipn, qty float64) error {
 	log.Println("processing our IPN: ", pn, qty)
 
 	// check if BOM exists
-	bomPath, err := findFile(pn.String() + ".csv")
+	bomPath, err := findBomFile(pn.String() + ".csv")
 	if err != nil {
 		return fmt.Errorf("Error finding sub assy BOM: %v", err)
 	}
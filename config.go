@@ -1,68 +1,456 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	PMDir string `yaml:"pmDir"`
+	PMDir        string           `yaml:"pmDir"`
+	TemplatesDir string           `yaml:"templatesDir"`
+	SourceRoots  []string         `yaml:"sourceRoots"`
+	Release      ReleaseConfig    `yaml:"release"`
+	CSV          CSVConfig        `yaml:"csv"`
+	Server       ServerConfig     `yaml:"server"`
+	Cache        CacheConfig      `yaml:"cache"`
+	Categories   []CategoryConfig `yaml:"categories"`
 }
 
-func loadConfig() (*Config, error) {
+// ReleaseConfig is the policy applied when processing releases
+type ReleaseConfig struct {
+	// RequireChangelog stops the release if the source dir has no CHANGELOG.md
+	RequireChangelog bool `yaml:"requireChangelog"`
+	// RequireMfg stops the release if the source dir has no MFG.md
+	RequireMfg bool `yaml:"requireMfg"`
+	// OutputFormats lists the formats release BOMs are written in (csv, json).
+	// Defaults to csv.
+	OutputFormats []string `yaml:"outputFormats"`
+	// PartmasterColumns lists extra partmaster columns copied into release
+	// BOMs, ex: Tolerance, Voltage
+	PartmasterColumns []string `yaml:"partmasterColumns"`
+}
+
+// CSVConfig describes how CSV files are read and written. The dialect of
+// existing files is detected, these settings are used for new files and when
+// detection is not possible.
+type CSVConfig struct {
+	// Delimiter is the field delimiter, defaults to ','
+	Delimiter string `yaml:"delimiter"`
+	// Encoding is utf-8 (default) or windows-1252
+	Encoding string `yaml:"encoding"`
+	// BOM writes a UTF-8 byte order mark at the start of new files
+	BOM bool `yaml:"bom"`
+	// Files overrides the dialect for files matching a pattern
+	Files []CSVFileConfig `yaml:"files"`
+	// SortRows sorts rows by IPN, then Priority when files are written
+	SortRows bool `yaml:"sortRows"`
+	// Aliases maps a column name to alternate header names, added to the
+	// built in aliases, ex: Qty: [Menge]
+	Aliases map[string][]string `yaml:"aliases"`
+}
+
+// CSVFileConfig sets the dialect for CSV files matching a pattern
+type CSVFileConfig struct {
+	// Pattern is matched against the file name and path
+	Pattern   string `yaml:"pattern"`
+	Delimiter string `yaml:"delimiter"`
+	Encoding  string `yaml:"encoding"`
+}
+
+// ServerConfig holds settings for the KiCad HTTP server
+type ServerConfig struct {
+	// Address is the address to listen on. Defaults to all interfaces.
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	// Token is accepted as the token of an admin user
+	Token string `yaml:"token"`
+	// UsersFile lists users of the server with their tokens and roles
+	UsersFile string `yaml:"usersFile"`
+	// AuditLog is the file changes made through the server are appended to.
+	// Defaults to gitplm-audit.jsonl in pmDir.
+	AuditLog string `yaml:"auditLog"`
+	// GitCommit commits files changed through the server to git, with the
+	// user who made the change as the author
+	GitCommit bool `yaml:"gitCommit"`
+	// ReloadInterval is the number of seconds between checks of pmDir for
+	// changed CSV files. Defaults to 2, and a negative value disables reloading.
+	ReloadInterval int `yaml:"reloadInterval"`
+	// Fields maps partmaster columns to KiCad fields for all categories
+	Fields []FieldConfig `yaml:"fields"`
+	// TLSCert and TLSKey are PEM files used to serve HTTPS
+	TLSCert string `yaml:"tlsCert"`
+	TLSKey  string `yaml:"tlsKey"`
+	// TLSSelfSigned serves HTTPS with a self-signed certificate, written to
+	// TLSCert and TLSKey if they are set and do not exist
+	TLSSelfSigned bool `yaml:"tlsSelfSigned"`
+	// ReadTimeout, WriteTimeout and IdleTimeout are in seconds. 0 is the
+	// default, and a negative value disables the timeout.
+	ReadTimeout  int `yaml:"readTimeout"`
+	WriteTimeout int `yaml:"writeTimeout"`
+	IdleTimeout  int `yaml:"idleTimeout"`
+}
+
+// FieldConfig maps a partmaster column to a field of parts served to KiCad
+type FieldConfig struct {
+	// Column is the partmaster column. Headers are matched ignoring case and
+	// punctuation, and using the column aliases.
+	Column string `yaml:"column"`
+	// Field is the KiCad field, ex: value, footprint, or keywords. Defaults
+	// to the column name.
+	Field string `yaml:"field"`
+	// Visible shows or hides the field in the schematic. If not set, KiCad
+	// decides.
+	Visible *bool `yaml:"visible"`
+	// Omit leaves the column out of parts served to KiCad
+	Omit bool `yaml:"omit"`
+}
+
+// CacheConfig enables the SQLite cache of the partmaster
+type CacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is the cache database file. Defaults to a file in the user cache
+	// directory.
+	Path string `yaml:"path"`
+}
+
+// CategoryConfig defines or overrides a part category (the CCC in an IPN)
+type CategoryConfig struct {
+	Code        string `yaml:"code"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Symbol is the default KiCad symbol for parts in the category
+	Symbol string `yaml:"symbol"`
+	// Fields maps partmaster columns to KiCad fields for parts in the
+	// category, and overrides server.fields
+	Fields []FieldConfig `yaml:"fields"`
+}
+
+// configFileNames are the names of config files in the workspace
+var configFileNames = []string{
+	"gitplm.yaml",
+	"gitplm.yml",
+	".gitplm.yaml",
+	".gitplm.yml",
+}
+
+// configEnvPrefix is the prefix for environment variables that override config
+// settings. The variable name is the prefix followed by the upper case YAML
+// key path joined with '_', ex: GITPLM_PMDIR, GITPLM_SERVER_PORT.
+const configEnvPrefix = "GITPLM"
+
+// loadConfig loads and merges config files in the following order, with later
+// files taking precedence:
+//
+//  1. home directory: ~/.gitplm.yaml or ~/.gitplm.yml
+//  2. the workspace root
+//  3. each directory between the workspace root and the current directory
+//     (project level config)
+//
+// Only the first config file found in each directory is used. Settings are
+// then overridden by GITPLM_* environment variables. A relative pmDir or
+// templatesDir, or server.usersFile, is resolved relative to the directory of
+// the config file it was set in.
+func loadConfig(root string) (*Config, error) {
 	config := &Config{}
-	
-	// Look for config file in current directory first, then home directory
-	configPaths := []string{
-		"gitplm.yaml",
-		"gitplm.yml",
-		".gitplm.yaml",
-		".gitplm.yml",
-	}
-	
-	// Also check home directory
+
+	dirs := []string{}
+
 	if homeDir, err := os.UserHomeDir(); err == nil {
-		homePaths := []string{
-			filepath.Join(homeDir, ".gitplm.yaml"),
-			filepath.Join(homeDir, ".gitplm.yml"),
+		dirs = append(dirs, homeDir)
+	}
+
+	projectDirs, err := configDirs(root)
+	if err != nil {
+		return nil, err
+	}
+	dirs = append(dirs, projectDirs...)
+
+	loaded := map[string]bool{}
+	for _, d := range dirs {
+		configPath := findConfigFile(d)
+		if configPath == "" {
+			continue
 		}
-		configPaths = append(configPaths, homePaths...)
-	}
-	
-	var configData []byte
-	var err error
-	
-	// Try to find and load a config file
-	for _, path := range configPaths {
-		if configData, err = os.ReadFile(path); err == nil {
-			break
+
+		// the workspace root may be the home directory
+		absPath, err := filepath.Abs(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if loaded[absPath] {
+			continue
+		}
+		loaded[absPath] = true
+
+		err = config.merge(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %v: %v", configPath, err)
 		}
 	}
-	
-	// If no config file found, return empty config (not an error)
+
+	err = applyConfigEnv(reflect.ValueOf(config).Elem(), configEnvPrefix)
 	if err != nil {
-		return config, nil
+		return nil, err
 	}
-	
-	err = yaml.Unmarshal(configData, config)
+
+	return config, nil
+}
+
+// configDirs returns the workspace root and every directory from there to
+// the current directory
+func configDirs(root string) ([]string, error) {
+	dirs := []string{root}
+
+	absRoot, err := filepath.Abs(root)
 	if err != nil {
 		return nil, err
 	}
-	
-	return config, nil
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(absRoot, cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	if rel == "." || strings.HasPrefix(rel, "..") {
+		return dirs, nil
+	}
+
+	d := root
+	for _, p := range strings.Split(rel, string(filepath.Separator)) {
+		d = filepath.Join(d, p)
+		dirs = append(dirs, d)
+	}
+
+	return dirs, nil
+}
+
+// findConfigFile returns the first config file found in dir, or "" if there
+// is none
+func findConfigFile(dir string) string {
+	for _, n := range configFileNames {
+		p := filepath.Join(dir, n)
+		if fileExists(p) {
+			return p
+		}
+	}
+	return ""
 }
 
+// merge decodes a config file over the current config. Settings that are not
+// in the file are not modified.
+func (c *Config) merge(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	prevPMDir := c.PMDir
+	prevTemplatesDir := c.TemplatesDir
+	prevUsersFile := c.Server.UsersFile
+	prevAuditLog := c.Server.AuditLog
+	prevTLSCert := c.Server.TLSCert
+	prevTLSKey := c.Server.TLSKey
+
+	err = yaml.Unmarshal(data, c)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(configPath)
+	if c.PMDir != prevPMDir && c.PMDir != "" && !filepath.IsAbs(c.PMDir) {
+		c.PMDir = filepath.Join(dir, c.PMDir)
+	}
+	if c.TemplatesDir != prevTemplatesDir && c.TemplatesDir != "" && !filepath.IsAbs(c.TemplatesDir) {
+		c.TemplatesDir = filepath.Join(dir, c.TemplatesDir)
+	}
+	if c.Server.UsersFile != prevUsersFile && c.Server.UsersFile != "" && !filepath.IsAbs(c.Server.UsersFile) {
+		c.Server.UsersFile = filepath.Join(dir, c.Server.UsersFile)
+	}
+	if c.Server.AuditLog != prevAuditLog && c.Server.AuditLog != "" && !filepath.IsAbs(c.Server.AuditLog) {
+		c.Server.AuditLog = filepath.Join(dir, c.Server.AuditLog)
+	}
+	if c.Server.TLSCert != prevTLSCert && c.Server.TLSCert != "" && !filepath.IsAbs(c.Server.TLSCert) {
+		c.Server.TLSCert = filepath.Join(dir, c.Server.TLSCert)
+	}
+	if c.Server.TLSKey != prevTLSKey && c.Server.TLSKey != "" && !filepath.IsAbs(c.Server.TLSKey) {
+		c.Server.TLSKey = filepath.Join(dir, c.Server.TLSKey)
+	}
+
+	return nil
+}
+
+// applyConfigEnv overrides string, bool, int and string list fields in v from
+// environment variables. String lists are separated by ','.
+func applyConfigEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(key)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			err := applyConfigEnv(fv, name)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(env)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(env)
+			if err != nil {
+				return fmt.Errorf("invalid value for %v: %v", name, err)
+			}
+			fv.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(env)
+			if err != nil {
+				return fmt.Errorf("invalid value for %v: %v", name, err)
+			}
+			fv.SetInt(int64(n))
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			var items []string
+			for _, s := range strings.Split(env, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					items = append(items, s)
+				}
+			}
+			fv.Set(reflect.ValueOf(items))
+		}
+	}
+
+	return nil
+}
+
+// category returns the config for a category code, or nil if it is not
+// configured
+func (c *Config) category(code string) *CategoryConfig {
+	for i := range c.Categories {
+		if c.Categories[i].Code == code {
+			return &c.Categories[i]
+		}
+	}
+	return nil
+}
+
+// saveConfig sets pmDir in the workspace root config file, creating gitplm.yml
+// if there is no config file. pmDir is stored relative to the workspace root,
+// unless it is an absolute path outside the workspace. Other settings and
+// comments in the file are preserved.
 func saveConfig(pmDir string) error {
-	config := Config{
-		PMDir: pmDir,
+	root := getWorkspaceRoot()
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	absPMDir, err := filepath.Abs(pmDir)
+	if err != nil {
+		return err
+	}
+	relPMDir, err := filepath.Rel(absRoot, absPMDir)
+	if err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(pmDir) || !strings.HasPrefix(relPMDir, "..") {
+		pmDir = relPMDir
+	}
+
+	configPath := findConfigFile(root)
+	if configPath == "" {
+		configPath = filepath.Join(root, "gitplm.yml")
 	}
 
-	data, err := yaml.Marshal(&config)
+	return setConfigValue(configPath, "pmDir", pmDir)
+}
+
+// setConfigValue sets a top level key in a YAML config file, preserving all
+// other keys and comments
+func setConfigValue(configPath, key, value string) error {
+	doc := yaml.Node{}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if len(bytes.TrimSpace(data)) > 0 {
+		err = yaml.Unmarshal(data, &doc)
+		if err != nil {
+			return fmt.Errorf("error parsing %v: %v", configPath, err)
+		}
+	}
+
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	m := doc.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return fmt.Errorf("error in %v: top level is not a map", configPath)
+	}
+
+	found := false
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			v := m.Content[i+1]
+			v.Kind = yaml.ScalarNode
+			v.Tag = "!!str"
+			v.Value = value
+			v.Content = nil
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		m.Content = append(m.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err != nil {
+		return err
+	}
+	err = enc.Close()
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile("gitplm.yml", data, 0644)
-}
\ No newline at end of file
+	return os.WriteFile(configPath, out.Bytes(), 0644)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	home := t.TempDir()
+	root := t.TempDir()
+	project := filepath.Join(root, "electrical", "pcb")
+
+	t.Setenv("HOME", home)
+	t.Setenv("GITPLM_SERVER_TOKEN", "envtoken")
+	t.Setenv("GITPLM_RELEASE_REQUIRECHANGELOG", "true")
+
+	files := map[string]string{
+		filepath.Join(home, ".gitplm.yml"): `
+pmDir: /home/parts
+server:
+  port: 9000
+  token: hometoken
+csv:
+  delimiter: ";"
+`,
+		filepath.Join(root, "gitplm.yml"): `
+pmDir: parts
+sourceRoots:
+  - electrical
+server:
+  usersFile: users.yml
+  auditLog: audit.jsonl
+  gitCommit: true
+categories:
+  - code: RES
+    name: Resistors (thin film)
+`,
+		filepath.Join(project, "gitplm.yml"): `
+templatesDir: templates
+csv:
+  delimiter: ","
+release:
+  outputFormats: [csv, json]
+`,
+	}
+
+	for f, c := range files {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	if err := os.Chdir(project); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	exp := &Config{
+		PMDir:        filepath.Join("..", "..", "parts"),
+		TemplatesDir: filepath.Join("..", "..", "electrical", "pcb", "templates"),
+		SourceRoots:  []string{"electrical"},
+		Release: ReleaseConfig{
+			RequireChangelog: true,
+			OutputFormats:    []string{"csv", "json"},
+		},
+		CSV: CSVConfig{Delimiter: ","},
+		Server: ServerConfig{
+			Port:      9000,
+			Token:     "envtoken",
+			UsersFile: filepath.Join("..", "..", "users.yml"),
+			AuditLog:  filepath.Join("..", "..", "audit.jsonl"),
+			GitCommit: true,
+		},
+		Categories: []CategoryConfig{
+			{Code: "RES", Name: "Resistors (thin film)"},
+		},
+	}
+
+	if !reflect.DeepEqual(config, exp) {
+		t.Errorf("wrong config:\nexp: %+v\ngot: %+v", exp, config)
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	root := t.TempDir()
+
+	configureWorkspace(root, nil)
+	defer configureWorkspace(".", nil)
+
+	configIn := `# partmaster location
+pmDir: old # set by TUI
+# release settings
+release:
+  requireChangelog: true
+custom: keep me
+`
+
+	configPath := filepath.Join(root, ".gitplm.yml")
+	err := os.WriteFile(configPath, []byte(configIn), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = saveConfig(filepath.Join(root, "parts"))
+	if err != nil {
+		t.Fatalf("error saving config: %v", err)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{
+		"# partmaster location",
+		"pmDir: parts # set by TUI",
+		"# release settings",
+		"requireChangelog: true",
+		"custom: keep me",
+	} {
+		if !strings.Contains(string(data), exp) {
+			t.Errorf("expected saved config to contain %q:\n%v", exp, string(data))
+		}
+	}
+
+	if fileExists(filepath.Join(root, "gitplm.yml")) {
+		t.Error("saveConfig should update the existing config file")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
+)
+
+// defaultColumnAliases maps the column names used by gitplm to alternate
+// names used by CAD tools and contract manufacturers. Aliases are compared
+// after normalizing with normalizeColumn, so case, spaces, and punctuation
+// do not matter.
+var defaultColumnAliases = map[string][]string{
+	"IPN":          {"Internal Part Number"},
+	"Qty":          {"Quantity", "Qnty", "Count"},
+	"Ref":          {"Reference", "References", "Designator", "Designators", "RefDes"},
+	"MPN":          {"Mfr. Part #", "Mfr Part Number", "Manufacturer Part Number", "MFG PN", "MfrPN"},
+	"Manufacturer": {"Mfr", "Mfg", "Manufacturer Name"},
+	"Cmp name":     {"Component Name", "LibRef"},
+	"Description":  {"Desc"},
+	"Footprint":    {"PCB Footprint"},
+	"Datasheet":    {"Datasheet URL"},
+}
+
+// columnAliases is the alias table used when loading CSV files. It is the
+// default table merged with aliases from the config.
+var columnAliases = defaultColumnAliases
+
+// setColumnAliases adds configured aliases to the default alias table
+func setColumnAliases(aliases map[string][]string) {
+	columnAliases = make(map[string][]string)
+	for k, v := range defaultColumnAliases {
+		columnAliases[k] = v
+	}
+
+	for k, v := range aliases {
+		columnAliases[k] = append(append([]string{}, columnAliases[k]...), v...)
+	}
+}
+
+// normalizeColumn lower cases a column name and removes spaces and
+// punctuation other than '#'
+func normalizeColumn(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '#' {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// matchColumn returns true if header is the column name or one of its aliases
+func matchColumn(header, column string) bool {
+	h := normalizeColumn(header)
+	if h == normalizeColumn(column) {
+		return true
+	}
+	for _, a := range columnAliases[column] {
+		if h == normalizeColumn(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalColumn returns the column name for a header that matches a known
+// column or one of its aliases, or the header unchanged
+func canonicalColumn(header string) string {
+	columns := lo.Keys(columnAliases)
+	sort.Strings(columns)
+	for _, c := range columns {
+		if matchColumn(header, c) {
+			return c
+		}
+	}
+	return header
+}
+
+// findColumn returns the index of a column in headers. An exact match is
+// preferred, then a case insensitive match or alias. Returns -1 if the column
+// is not found.
+func findColumn(headers []string, column string) int {
+	for i, h := range headers {
+		if strings.TrimSpace(h) == column {
+			return i
+		}
+	}
+	for i, h := range headers {
+		if matchColumn(h, column) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mapColumns renames headers that match one of the column names or aliases to
+// the column name. Headers that do not match, and aliases for columns that
+// are already present, are left alone.
+func mapColumns(headers, columns []string) []string {
+	out := append([]string{}, headers...)
+	for _, c := range columns {
+		idx := findColumn(headers, c)
+		if idx >= 0 {
+			out[idx] = c
+		}
+	}
+	return out
+}
+
+// csvColumns returns the csv tags of the struct fields for the element type
+// of a slice, ex: *bom or *partmaster
+func csvColumns(target any) []string {
+	t := reflect.TypeOf(target)
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("csv"), ",")[0]
+		if tag != "" && tag != "-" {
+			columns = append(columns, tag)
+		}
+	}
+	return columns
+}
+
+// csvField is a column name and value
+type csvField struct {
+	Name  string
+	Value string
+}
+
+// csvExtra holds columns that are not mapped to a struct field, in the order
+// they appear in the file
+type csvExtra []csvField
+
+// get returns the value of a column, matching the name ignoring case
+func (e csvExtra) get(name string) (string, bool) {
+	for _, f := range e {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	for _, f := range e {
+		if normalizeColumn(f.Name) == normalizeColumn(name) {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// set sets the value of a column, adding it if it does not exist. Lines are
+// often shallow copied, so a new slice is always created.
+func (e *csvExtra) set(name, value string) {
+	out := append(csvExtra{}, *e...)
+	for i := range out {
+		if out[i].Name == name {
+			out[i].Value = value
+			*e = out
+			return
+		}
+	}
+	*e = append(out, csvField{name, value})
+}
+
+// MarshalJSON writes extra columns as an object, keeping the column order
+func (e csvExtra) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range e {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// extraValue returns the Extra field of a slice element, or an invalid value
+// if the element type has none
+func extraValue(el reflect.Value) reflect.Value {
+	for el.Kind() == reflect.Pointer {
+		if el.IsNil() {
+			return reflect.Value{}
+		}
+		el = el.Elem()
+	}
+	if el.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	f := el.FieldByName("Extra")
+	if !f.IsValid() || f.Type() != reflect.TypeOf(csvExtra{}) {
+		return reflect.Value{}
+	}
+	return f
+}
+
+// setExtraColumns stores columns in records that are not mapped to a field of
+// the target element type in the Extra field of each element. records[0] is
+// the header.
+func setExtraColumns(target any, records [][]string) {
+	if len(records) == 0 {
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, c := range csvColumns(target) {
+		known[c] = true
+	}
+
+	var extra []int
+	for i, h := range records[0] {
+		if h != "" && !known[h] {
+			extra = append(extra, i)
+		}
+	}
+	if len(extra) == 0 {
+		return
+	}
+
+	v := reflect.ValueOf(target)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice {
+		return
+	}
+
+	for i := 0; i < v.Len() && i+1 < len(records); i++ {
+		f := extraValue(v.Index(i))
+		if !f.IsValid() {
+			return
+		}
+		rec := records[i+1]
+		e := csvExtra{}
+		for _, idx := range extra {
+			val := ""
+			if idx < len(rec) {
+				val = rec[idx]
+			}
+			e = append(e, csvField{records[0][idx], val})
+		}
+		f.Set(reflect.ValueOf(e))
+	}
+}
+
+// addExtraColumns appends the Extra columns of the elements in data to the
+// records written for them. Columns are added in the order they first appear.
+func addExtraColumns(records [][]string, data any) [][]string {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice || len(records) == 0 {
+		return records
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	for _, h := range records[0] {
+		seen[h] = true
+	}
+	for i := 0; i < v.Len(); i++ {
+		f := extraValue(v.Index(i))
+		if !f.IsValid() {
+			return records
+		}
+		for _, c := range f.Interface().(csvExtra) {
+			if !seen[c.Name] {
+				seen[c.Name] = true
+				columns = append(columns, c.Name)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return records
+	}
+
+	records[0] = append(records[0], columns...)
+	for i := 0; i < v.Len() && i+1 < len(records); i++ {
+		e := extraValue(v.Index(i)).Interface().(csvExtra)
+		for _, c := range columns {
+			val, _ := e.get(c)
+			records[i+1] = append(records[i+1], val)
+		}
+	}
+
+	return records
+}
+
+// recordReader is a gocsv.CSVReader for records that have already been read
+type recordReader struct {
+	records [][]string
+}
+
+func (r *recordReader) Read() ([]string, error) {
+	if len(r.records) == 0 {
+		return nil, io.EOF
+	}
+	rec := r.records[0]
+	r.records = r.records[1:]
+	return rec, nil
+}
+
+func (r *recordReader) ReadAll() ([][]string, error) {
+	recs := r.records
+	r.records = nil
+	return recs, nil
+}
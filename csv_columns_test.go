@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCSVColumnAliases(t *testing.T) {
+	initCSV()
+	defer setColumnAliases(nil)
+	setColumnAliases(map[string][]string{"Vendor": {"Lieferant"}})
+
+	p := filepath.Join(t.TempDir(), "bom.csv")
+	data := `Designator,Quantity,Mfr. Part #,MANUFACTURER,ipn,Lieferant,Comment
+"C1,C2",2,abc,CapsInc,CAP-001-1001,Digikey,x
+R1,1,def,ResInc,RES-002-0001,Mouser,y
+`
+	if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := bom{}
+	if err := loadCSV(p, &b); err != nil {
+		t.Fatalf("error loading bom: %v", err)
+	}
+
+	if len(b) != 2 {
+		t.Fatalf("expected 2 lines, got %v", len(b))
+	}
+
+	l := b[0]
+	if l.Ref != "C1,C2" || l.Qty != 2 || l.MPN != "abc" || l.Manufacturer != "CapsInc" ||
+		l.IPN != "CAP-001-1001" || l.Vendor != "Digikey" {
+		t.Errorf("columns not mapped: %+v", l)
+	}
+}
+
+func TestParseFileAsPartmasterAliases(t *testing.T) {
+	f := &CSVFile{
+		Headers: []string{"ipn", "Desc", "Mfr", "Manufacturer Part Number", "priority"},
+		Rows: [][]string{
+			{"CAP-001-1001", "cap", "CapsInc", "abc", "2"},
+		},
+	}
+
+	pm, err := (&CSVFileCollection{}).parseFileAsPartmaster(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pm) != 1 {
+		t.Fatalf("expected 1 part, got %v", len(pm))
+	}
+
+	p := pm[0]
+	if p.Description != "cap" || p.Manufacturer != "CapsInc" || p.MPN != "abc" || p.Priority != 2 {
+		t.Errorf("columns not mapped: %+v", p)
+	}
+}
+
+func TestFindColumnPrefersExact(t *testing.T) {
+	headers := []string{"Designator", "Ref"}
+	if i := findColumn(headers, "Ref"); i != 1 {
+		t.Errorf("expected exact match at 1, got %v", i)
+	}
+
+	if i := findColumn([]string{"IPN", "MPN2"}, "MPN"); i != -1 {
+		t.Errorf("MPN2 should not match MPN, got %v", i)
+	}
+}
+
+func TestExtraColumns(t *testing.T) {
+	initCSV()
+	dir := t.TempDir()
+
+	bomPath := filepath.Join(dir, "bom.csv")
+	bomData := `IPN,Qty,Ref,DNP,Note
+CAP-001-1001,2,C1 C2,,check polarity
+RES-002-0001,1,R1,yes,
+`
+	if err := os.WriteFile(bomPath, []byte(bomData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := bom{}
+	if err := loadCSV(bomPath, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := b[0].Extra.get("note"); v != "check polarity" {
+		t.Errorf("extra column not loaded: %+v", b[0].Extra)
+	}
+
+	pmPath := filepath.Join(dir, "cap.csv")
+	pmData := `IPN,Description,MPN,Tolerance,Voltage
+CAP-001-1001,cap,abc,10%,16V
+RES-002-0001,res,def,1%,
+`
+	if err := os.WriteFile(pmPath, []byte(pmData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pm := partmaster{}
+	if err := loadCSV(pmPath, &pm); err != nil {
+		t.Fatal(err)
+	}
+
+	b.mergePartmaster(pm, []string{"Tolerance", "voltage"}, func(s string) {
+		t.Errorf("merge error: %v", s)
+	})
+
+	outPath := filepath.Join(dir, "out.csv")
+	if err := saveCSV(outPath, b); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := loadCSVRaw(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expHeaders := []string{"DNP", "Note", "Tolerance", "voltage"}
+	gotHeaders := out.Headers[len(out.Headers)-len(expHeaders):]
+	for i := range expHeaders {
+		if gotHeaders[i] != expHeaders[i] {
+			t.Fatalf("expected extra headers %v, got %v", expHeaders, out.Headers)
+		}
+	}
+
+	row := out.Rows[0][len(out.Headers)-len(expHeaders):]
+	expRow := []string{"", "check polarity", "10%", "16V"}
+	for i := range expRow {
+		if row[i] != expRow[i] {
+			t.Errorf("expected extra values %v, got %v", expRow, row)
+		}
+	}
+
+	data, err := json.Marshal(b[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"extra":{"DNP":"yes","Note":"","Tolerance":"1%","voltage":""}`) {
+		t.Errorf("wrong json: %s", data)
+	}
+}
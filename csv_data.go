@@ -15,6 +15,27 @@ type CSVFile struct {
 	Path    string
 	Headers []string
 	Rows    [][]string
+	// Dialect is the delimiter, encoding and byte order mark the file was
+	// read in, and is written back in
+	Dialect csvDialect
+	// layout is the formatting of the file when it was read
+	layout *csvLayout
+	// state identifies the file on disk when it was read or last saved, and
+	// is used to detect changes made by others before saving
+	state *fileState
+}
+
+// clone returns a copy of the file whose headers and rows can be edited
+// without changing the original. The layout and state are shared, as they are
+// replaced rather than modified when the copy is saved.
+func (f *CSVFile) clone() *CSVFile {
+	c := *f
+	c.Headers = append([]string{}, f.Headers...)
+	c.Rows = make([][]string, len(f.Rows))
+	for i, row := range f.Rows {
+		c.Rows[i] = append([]string{}, row...)
+	}
+	return &c
 }
 
 // CSVFileCollection represents all CSV files loaded from a directory
@@ -24,22 +45,56 @@ type CSVFileCollection struct {
 
 // loadCSVRaw loads a CSV file without struct mapping, preserving all columns
 func loadCSVRaw(filePath string) (*CSVFile, error) {
-	file, err := os.Open(filePath)
+	raw, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file %s: %v", filePath, err)
 	}
-	defer file.Close()
+	state := newFileState(filePath, raw)
+
+	data, dialect, err := decodeCSVFile(filePath, raw)
+	if err != nil {
+		return nil, err
+	}
 
-	reader := csv.NewReader(file)
-	reader.Comma = ','
+	headers, rows, layout, err := parseCSVRaw(filePath, string(data), dialect.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CSVFile{
+		Name:    filepath.Base(filePath),
+		Path:    filePath,
+		Headers: headers,
+		Rows:    rows,
+		Dialect: dialect,
+		layout:  layout,
+		state:   &state,
+	}, nil
+}
+
+// parseCSVRaw parses CSV text into headers and rows, and records the layout of
+// the text so it can be written back with minimal changes
+func parseCSVRaw(filePath, text string, delim rune) ([]string, [][]string, *csvLayout, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delim
 	reader.LazyQuotes = true
 	reader.FieldsPerRecord = -1 // Allow variable number of fields per record
 
+	layout := &csvLayout{}
+	offset := int64(0)
+	record := func(fields []string) csvRecord {
+		next := reader.InputOffset()
+		r := newCSVRecord(text[offset:next], fields, delim)
+		offset = next
+		return r
+	}
+
 	// Read headers
 	headers, err := reader.Read()
 	if err != nil {
-		return nil, fmt.Errorf("error reading headers from %s: %v", filePath, err)
+		return nil, nil, nil, fmt.Errorf("error reading headers from %s: %v", filePath, err)
 	}
+	layout.header = record(headers)
 
 	// Trim whitespace from headers
 	for i := range headers {
@@ -57,25 +112,35 @@ func loadCSVRaw(filePath string) (*CSVFile, error) {
 		if err != nil {
 			// Skip malformed rows and continue
 			fmt.Printf("Warning: error reading row %d from %s: %v\n", lineNum, filePath, err)
+			offset = reader.InputOffset()
 			lineNum++
 			continue
 		}
+		layout.records = append(layout.records, record(row))
 		rows = append(rows, row)
 		lineNum++
 	}
+	layout.finish(text)
+
+	return headers, rows, layout, nil
+}
 
+// partmasterHeaders are the standard headers for new partmaster files
+var partmasterHeaders = []string{"IPN", "Description", "Footprint", "Value", "Manufacturer", "MPN", "Datasheet", "Priority", "Checked"}
+
+// newCSVFile returns an empty CSV file that has not been written yet
+func newCSVFile(path string, headers []string) *CSVFile {
 	return &CSVFile{
-		Name:    filepath.Base(filePath),
-		Path:    filePath,
-		Headers: headers,
-		Rows:    rows,
-	}, nil
+		Name:    filepath.Base(path),
+		Path:    path,
+		Headers: append([]string{}, headers...),
+		Rows:    [][]string{},
+		Dialect: csvDialectForWrite(path),
+	}
 }
 
 // createBlankPartmasterCSV creates an empty partmaster.csv file with standard headers
 func createBlankPartmasterCSV(dir string) (*CSVFile, error) {
-	headers := []string{"IPN", "Description", "Footprint", "Value", "Manufacturer", "MPN", "Datasheet", "Priority", "Checked"}
-
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		tmpDir := os.TempDir()
 		fmt.Printf("Warning: could not create directory %s: %v; using %s instead\n", dir, err, tmpDir)
@@ -84,31 +149,37 @@ func createBlankPartmasterCSV(dir string) (*CSVFile, error) {
 	}
 
 	path := filepath.Join(dir, "partmaster.csv")
-	file, err := os.Create(path)
-	if err != nil {
+	file := newCSVFile(path, partmasterHeaders)
+
+	if err := saveCSVFile(file); err != nil {
 		return nil, fmt.Errorf("error creating file %s: %v", path, err)
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	if err := writer.Write(headers); err != nil {
-		return nil, fmt.Errorf("error writing headers to %s: %v", path, err)
+	return file, nil
+}
+
+// loadAllCSVFiles loads all CSV files from a directory. If there are no CSV
+// files that can be loaded, a blank partmaster.csv is created.
+func loadAllCSVFiles(dir string) (*CSVFileCollection, error) {
+	collection, err := readCSVFiles(dir)
+	if err != nil {
+		return nil, err
 	}
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		return nil, fmt.Errorf("error finalizing file %s: %v", path, err)
+
+	if len(collection.Files) == 0 {
+		csvFile, err := createBlankPartmasterCSV(dir)
+		if err != nil {
+			return nil, err
+		}
+		collection.Files = append(collection.Files, csvFile)
 	}
 
-	return &CSVFile{
-		Name:    filepath.Base(path),
-		Path:    path,
-		Headers: headers,
-		Rows:    [][]string{},
-	}, nil
+	return collection, nil
 }
 
-// loadAllCSVFiles loads all CSV files from a directory
-func loadAllCSVFiles(dir string) (*CSVFileCollection, error) {
+// readCSVFiles loads the CSV files in a directory that can be parsed. Files
+// are not created, so the collection may be empty.
+func readCSVFiles(dir string) (*CSVFileCollection, error) {
 	collection := &CSVFileCollection{
 		Files: []*CSVFile{},
 	}
@@ -118,15 +189,6 @@ func loadAllCSVFiles(dir string) (*CSVFileCollection, error) {
 		return nil, fmt.Errorf("error finding CSV files in directory %s: %v", dir, err)
 	}
 
-	if len(files) == 0 {
-		csvFile, err := createBlankPartmasterCSV(dir)
-		if err != nil {
-			return nil, err
-		}
-		collection.Files = append(collection.Files, csvFile)
-		return collection, nil
-	}
-
 	for _, filePath := range files {
 		csvFile, err := loadCSVRaw(filePath)
 		if err != nil {
@@ -137,14 +199,6 @@ func loadAllCSVFiles(dir string) (*CSVFileCollection, error) {
 		collection.Files = append(collection.Files, csvFile)
 	}
 
-	if len(collection.Files) == 0 {
-		csvFile, err := createBlankPartmasterCSV(dir)
-		if err != nil {
-			return nil, err
-		}
-		collection.Files = append(collection.Files, csvFile)
-	}
-
 	return collection, nil
 }
 
@@ -165,73 +219,75 @@ func (c *CSVFileCollection) GetCombinedPartmaster() (partmaster, error) {
 	return pm, nil
 }
 
-// saveCSVFile writes a CSVFile back to disk, preserving headers and rows
+// saveCSVFile writes a CSVFile back to disk, preserving headers and rows. The
+// file is written in the dialect it was read in, and only edited cells are
+// changed. Rows are sorted by IPN and Priority if csv.sortRows is set in the
+// config.
+//
+// The directory is locked while the file is written, and the file is replaced
+// atomically. If the file was modified on disk since it was loaded, a
+// conflictError is returned and the file is not written.
 func saveCSVFile(file *CSVFile) error {
-	f, err := os.Create(file.Path)
-	if err != nil {
-		return fmt.Errorf("error opening file %s: %w", file.Path, err)
+	if file.Dialect.Delimiter == 0 {
+		file.Dialect = csvDialectForWrite(file.Path)
 	}
-	defer f.Close()
 
-	w := csv.NewWriter(f)
-	w.Comma = ','
-
-	if err := w.Write(file.Headers); err != nil {
-		return fmt.Errorf("error writing headers: %w", err)
+	unlock, err := lockDir(filepath.Dir(file.Path))
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	for _, row := range file.Rows {
-		if len(row) < len(file.Headers) {
-			padded := make([]string, len(file.Headers))
-			copy(padded, row)
-			row = padded
+	if file.state != nil {
+		changed, err := file.state.changed(file.Path)
+		if err != nil {
+			return err
 		}
-		if err := w.Write(row); err != nil {
-			return fmt.Errorf("error writing row: %w", err)
+		if changed {
+			return &conflictError{path: file.Path}
 		}
 	}
 
-	w.Flush()
-	return w.Error()
+	if csvConfig.SortRows {
+		sortCSVRows(file)
+	}
+
+	text := encodeCSVFile(file)
+	data, err := writeCSVFile(file.Path, []byte(text), file.Dialect)
+	if err != nil {
+		return err
+	}
+
+	// the file as written is the base for the next save
+	state := newFileState(file.Path, data)
+	file.state = &state
+	_, _, layout, err := parseCSVRaw(file.Path, text, file.Dialect.Delimiter)
+	if err == nil {
+		file.layout = layout
+	}
+
+	return nil
 }
 
 // parseFileAsPartmaster attempts to parse a CSV file as partmaster format
 func (c *CSVFileCollection) parseFileAsPartmaster(file *CSVFile) (partmaster, error) {
 	pm := partmaster{}
 
-	// Find column indices for partmaster fields
-	ipnIdx := -1
-	descIdx := -1
-	footprintIdx := -1
-	valueIdx := -1
-	mfrIdx := -1
-	mpnIdx := -1
-	datasheetIdx := -1
-	priorityIdx := -1
-	checkedIdx := -1
-
-	for i, header := range file.Headers {
-		switch header {
-		case "IPN":
-			ipnIdx = i
-		case "Description":
-			descIdx = i
-		case "Footprint":
-			footprintIdx = i
-		case "Value":
-			valueIdx = i
-		case "Manufacturer":
-			mfrIdx = i
-		case "MPN":
-			mpnIdx = i
-		case "Datasheet":
-			datasheetIdx = i
-		case "Priority":
-			priorityIdx = i
-		case "Checked":
-			checkedIdx = i
-		}
-	}
+	// Find column indices for partmaster fields. Headers are matched ignoring
+	// case, and using the column aliases.
+	ipnIdx := findColumn(file.Headers, "IPN")
+	descIdx := findColumn(file.Headers, "Description")
+	footprintIdx := findColumn(file.Headers, "Footprint")
+	valueIdx := findColumn(file.Headers, "Value")
+	mfrIdx := findColumn(file.Headers, "Manufacturer")
+	mpnIdx := findColumn(file.Headers, "MPN")
+	datasheetIdx := findColumn(file.Headers, "Datasheet")
+	priorityIdx := findColumn(file.Headers, "Priority")
+	checkedIdx := findColumn(file.Headers, "Checked")
+
+	known := map[int]bool{ipnIdx: true, descIdx: true, footprintIdx: true,
+		valueIdx: true, mfrIdx: true, mpnIdx: true, datasheetIdx: true,
+		priorityIdx: true, checkedIdx: true}
 
 	// Must have at least IPN column to be valid
 	if ipnIdx == -1 {
@@ -282,6 +338,18 @@ func (c *CSVFileCollection) parseFileAsPartmaster(file *CSVFile) (partmaster, er
 			line.Checked = row[checkedIdx]
 		}
 
+		// keep all other columns
+		for i, header := range file.Headers {
+			if header == "" || known[i] {
+				continue
+			}
+			value := ""
+			if i < len(row) {
+				value = row[i]
+			}
+			line.Extra = append(line.Extra, csvField{header, value})
+		}
+
 		pm = append(pm, line)
 	}
 
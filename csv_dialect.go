@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/samber/lo"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	encodingUTF8        = "utf-8"
+	encodingWindows1252 = "windows-1252"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// csvDialect describes how a CSV file is stored on disk. Files are written
+// back in the dialect they were read in so diffs stay minimal.
+type csvDialect struct {
+	Delimiter rune
+	// BOM is true if the file starts with a UTF-8 byte order mark
+	BOM bool
+	// Encoding is the character encoding, utf-8 or windows-1252
+	Encoding string
+}
+
+// csvConfig holds the CSV settings from the config file
+var csvConfig CSVConfig
+
+// setCSVConfig sets the CSV settings from the config, and the default
+// delimiter used for new files and files where it can't be detected
+func setCSVConfig(c CSVConfig) error {
+	err := setCSVDelimiter(c.Delimiter)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range append([]string{c.Encoding}, lo.Map(c.Files, func(f CSVFileConfig, _ int) string {
+		return f.Encoding
+	})...) {
+		if e != "" && e != encodingUTF8 && e != encodingWindows1252 {
+			return fmt.Errorf("unsupported CSV encoding: %v", e)
+		}
+	}
+
+	for _, f := range c.Files {
+		if _, err := filepath.Match(f.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid CSV file pattern %q: %v", f.Pattern, err)
+		}
+		if _, err := parseCSVDelimiter(f.Delimiter); err != nil {
+			return err
+		}
+	}
+
+	csvConfig = c
+	setColumnAliases(c.Aliases)
+	return nil
+}
+
+// defaultCSVDialect returns the dialect used for new files
+func defaultCSVDialect() csvDialect {
+	d := csvDialect{
+		Delimiter: csvDelimiter,
+		BOM:       csvConfig.BOM,
+		Encoding:  csvConfig.Encoding,
+	}
+	if d.Encoding == "" {
+		d.Encoding = encodingUTF8
+	}
+	return d
+}
+
+// configuredCSVDialect returns the config entry for a file, if any. Patterns
+// are matched against the file name and the path.
+func configuredCSVDialect(filePath string) *CSVFileConfig {
+	for i, f := range csvConfig.Files {
+		if m, _ := filepath.Match(f.Pattern, filepath.Base(filePath)); m {
+			return &csvConfig.Files[i]
+		}
+		if m, _ := filepath.Match(f.Pattern, filepath.ToSlash(filePath)); m {
+			return &csvConfig.Files[i]
+		}
+	}
+	return nil
+}
+
+// detectCSVDialect determines the dialect of a file from its contents.
+// Settings in a matching config entry override detected values.
+func detectCSVDialect(filePath string, data []byte) csvDialect {
+	d := defaultCSVDialect()
+
+	d.BOM = bytes.HasPrefix(data, utf8BOM)
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	d.Encoding = encodingUTF8
+	if !utf8.Valid(data) {
+		d.Encoding = encodingWindows1252
+	}
+
+	if delim, ok := detectCSVDelimiter(data); ok {
+		d.Delimiter = delim
+	}
+
+	if c := configuredCSVDialect(filePath); c != nil {
+		if c.Delimiter != "" {
+			d.Delimiter, _ = parseCSVDelimiter(c.Delimiter)
+		}
+		if c.Encoding != "" {
+			d.Encoding = c.Encoding
+		}
+	}
+
+	return d
+}
+
+// detectCSVDelimiter picks the most common delimiter candidate outside of
+// quotes in the header line
+func detectCSVDelimiter(data []byte) (rune, bool) {
+	candidates := []rune{',', ';', '\t'}
+	counts := make(map[rune]int)
+
+	inQuotes := false
+	for _, c := range string(data) {
+		if c == '"' {
+			inQuotes = !inQuotes
+			continue
+		}
+		if inQuotes {
+			continue
+		}
+		if c == '\n' {
+			break
+		}
+		counts[c]++
+	}
+
+	best := rune(0)
+	for _, c := range candidates {
+		if counts[c] > counts[best] {
+			best = c
+		}
+	}
+
+	return best, best != 0
+}
+
+// decode converts file contents to UTF-8 without a byte order mark
+func (d csvDialect) decode(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if d.Encoding == encodingWindows1252 {
+		return charmap.Windows1252.NewDecoder().Bytes(data)
+	}
+	return data, nil
+}
+
+// encode converts UTF-8 contents to the encoding of the dialect
+func (d csvDialect) encode(data []byte) ([]byte, error) {
+	if d.Encoding == encodingWindows1252 {
+		var err error
+		data, err = charmap.Windows1252.NewEncoder().Bytes(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.BOM {
+		data = append(append([]byte{}, utf8BOM...), data...)
+	}
+	return data, nil
+}
+
+// readCSVFile reads a CSV file and returns the contents as UTF-8 along with
+// the dialect of the file
+func readCSVFile(filePath string) ([]byte, csvDialect, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, csvDialect{}, err
+	}
+
+	return decodeCSVFile(filePath, data)
+}
+
+// decodeCSVFile detects the dialect of file contents and converts them to
+// UTF-8
+func decodeCSVFile(filePath string, data []byte) ([]byte, csvDialect, error) {
+	d := detectCSVDialect(filePath, data)
+	data, err := d.decode(data)
+	if err != nil {
+		return nil, d, fmt.Errorf("error decoding %v as %v: %v", filePath, d.Encoding, err)
+	}
+
+	return data, d, nil
+}
+
+// writeCSVFile writes UTF-8 CSV contents to a file in the given dialect. The
+// file is replaced atomically. The encoded contents are returned.
+func writeCSVFile(filePath string, data []byte, d csvDialect) ([]byte, error) {
+	data, err := d.encode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %v as %v: %v", filePath, d.Encoding, err)
+	}
+
+	return data, writeFileAtomic(filePath, data, 0644)
+}
+
+// csvDialectForWrite returns the dialect of an existing file so it is
+// written back the same way, or the default dialect for new files
+func csvDialectForWrite(filePath string) csvDialect {
+	data, err := os.ReadFile(filePath)
+	if err != nil || len(data) == 0 {
+		d := defaultCSVDialect()
+		if c := configuredCSVDialect(filePath); c != nil {
+			if c.Delimiter != "" {
+				d.Delimiter, _ = parseCSVDelimiter(c.Delimiter)
+			}
+			if c.Encoding != "" {
+				d.Encoding = c.Encoding
+			}
+		}
+		return d
+	}
+
+	return detectCSVDialect(filePath, data)
+}
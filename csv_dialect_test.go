@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCSVDialectRoundTrip(t *testing.T) {
+	initCSV()
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected csvDialect
+	}{
+		{"comma.csv", []byte("IPN,Description,MPN\nCAP-001-1001,cap,abc\n"),
+			csvDialect{',', false, encodingUTF8}},
+		{"semicolon.csv", []byte("\xEF\xBB\xBFIPN;Description;MPN\nCAP-001-1001;\"cap; 10uF\";abc\n"),
+			csvDialect{';', true, encodingUTF8}},
+		{"tab.csv", []byte("IPN\tDescription\tMPN\nCAP-001-1001\tcap \xB5F\tabc\n"),
+			csvDialect{'\t', false, encodingWindows1252}},
+	}
+
+	for _, test := range tests {
+		p := filepath.Join(dir, test.name)
+		if err := os.WriteFile(p, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := loadCSVRaw(p)
+		if err != nil {
+			t.Fatalf("%v: error loading: %v", test.name, err)
+		}
+
+		if f.Dialect != test.expected {
+			t.Errorf("%v: expected dialect %+v, got %+v", test.name, test.expected, f.Dialect)
+		}
+
+		if len(f.Rows) != 1 || len(f.Rows[0]) != 3 {
+			t.Fatalf("%v: wrong rows: %v", test.name, f.Rows)
+		}
+
+		if test.expected.Encoding == encodingWindows1252 && f.Rows[0][1] != "cap µF" {
+			t.Errorf("%v: not decoded: %q", test.name, f.Rows[0][1])
+		}
+
+		if err := saveCSVFile(f); err != nil {
+			t.Fatalf("%v: error saving: %v", test.name, err)
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%v: file changed after round trip:\n%q\n%q", test.name, test.data, data)
+		}
+
+		// struct based load/save must also keep the dialect
+		pm := partmaster{}
+		if err := loadCSV(p, &pm); err != nil {
+			t.Fatalf("%v: error loading partmaster: %v", test.name, err)
+		}
+		if len(pm) != 1 || pm[0].MPN != "abc" {
+			t.Fatalf("%v: wrong partmaster: %+v", test.name, pm)
+		}
+		if err := saveCSV(p, pm); err != nil {
+			t.Fatal(err)
+		}
+		f, err = loadCSVRaw(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Dialect != test.expected {
+			t.Errorf("%v: saveCSV changed dialect to %+v", test.name, f.Dialect)
+		}
+	}
+}
+
+func TestCSVDialectConfig(t *testing.T) {
+	defer setCSVConfig(CSVConfig{})
+
+	err := setCSVConfig(CSVConfig{
+		Delimiter: ";",
+		BOM:       true,
+		Files: []CSVFileConfig{
+			{Pattern: "legacy-*.csv", Delimiter: `\t`, Encoding: encodingWindows1252},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setCSVDelimiter(",")
+
+	d := csvDialectForWrite(filepath.Join(t.TempDir(), "new.csv"))
+	if d != (csvDialect{';', true, encodingUTF8}) {
+		t.Errorf("wrong default dialect: %+v", d)
+	}
+
+	// configured encoding wins over detection, a file that is also valid
+	// UTF-8 is still read as windows-1252
+	d = detectCSVDialect("parts/legacy-cap.csv", []byte("IPN,MPN\n"))
+	if d.Delimiter != '\t' || d.Encoding != encodingWindows1252 {
+		t.Errorf("config not applied: %+v", d)
+	}
+
+	if err := setCSVConfig(CSVConfig{Encoding: "latin9"}); err == nil {
+		t.Errorf("expected error for unsupported encoding")
+	}
+}
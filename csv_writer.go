@@ -0,0 +1,243 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// csvRecord is a record as it was read from a CSV file
+type csvRecord struct {
+	// text is the record exactly as read, without the line ending
+	text string
+	// fields are the parsed values
+	fields []string
+	// raw are the fields as they appear in text, including quotes. nil if
+	// the record could not be split.
+	raw []string
+}
+
+// csvLayout records how a CSV file was formatted when it was read, so it can
+// be written back with only the edited cells changed
+type csvLayout struct {
+	lineEnding      string
+	trailingNewline bool
+	header          csvRecord
+	records         []csvRecord
+	// quoted is true for columns where every value was quoted
+	quoted []bool
+}
+
+// newCSVLayout returns the layout used for new files
+func newCSVLayout() *csvLayout {
+	return &csvLayout{lineEnding: "\n", trailingNewline: true}
+}
+
+// newCSVRecord creates a record from the raw text and parsed values
+func newCSVRecord(text string, fields []string, delim rune) csvRecord {
+	text = strings.TrimRight(text, "\r\n")
+	r := csvRecord{
+		text:   text,
+		fields: append([]string{}, fields...),
+	}
+
+	raw := splitRawFields(strings.TrimLeft(text, "\r\n"), delim)
+	if len(raw) == len(fields) {
+		r.raw = raw
+	}
+
+	return r
+}
+
+// splitRawFields splits the text of a record on delimiters outside of quotes
+func splitRawFields(text string, delim rune) []string {
+	var fields []string
+	inQuotes := false
+	start := 0
+	for i, c := range text {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == delim && !inQuotes:
+			fields = append(fields, text[start:i])
+			start = i + utf8.RuneLen(c)
+		}
+	}
+	return append(fields, text[start:])
+}
+
+// finish sets the line ending and column quoting once all records are read
+func (l *csvLayout) finish(data string) {
+	l.lineEnding = "\n"
+	if i := strings.IndexByte(data, '\n'); i > 0 && data[i-1] == '\r' {
+		l.lineEnding = "\r\n"
+	}
+	l.trailingNewline = len(data) == 0 || strings.HasSuffix(data, "\n")
+
+	l.quoted = make([]bool, len(l.header.fields))
+	for i := range l.quoted {
+		l.quoted[i] = len(l.records) > 0
+		for _, r := range l.records {
+			if r.raw == nil || i >= len(r.raw) || !isQuoted(r.raw[i]) {
+				l.quoted[i] = false
+				break
+			}
+		}
+	}
+}
+
+func isQuoted(raw string) bool {
+	return len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"'
+}
+
+// fieldNeedsQuotes matches the rules used by encoding/csv
+func fieldNeedsQuotes(field string, delim rune) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` || strings.ContainsRune(field, delim) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+// encodeField formats a value, quoting it if needed or if quote is set
+func encodeField(value string, delim rune, quote bool) string {
+	if !quote && !fieldNeedsQuotes(value, delim) {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+// encodeCSVFile formats a CSV file. Records that have not changed since the
+// file was read are written exactly as they were read. In changed records,
+// unchanged cells keep their original formatting, and edited cells are
+// quoted like the cell they replace.
+func encodeCSVFile(file *CSVFile) string {
+	l := file.layout
+	if l == nil {
+		l = newCSVLayout()
+	}
+	delim := file.Dialect.Delimiter
+
+	// map current columns to the columns in the original file
+	colMap := make([]int, len(file.Headers))
+	usedCol := make(map[int]bool)
+	identity := len(file.Headers) == len(l.header.fields)
+	for i, h := range file.Headers {
+		colMap[i] = -1
+		for j, o := range l.header.fields {
+			if !usedCol[j] && strings.TrimSpace(o) == h {
+				colMap[i] = j
+				usedCol[j] = true
+				break
+			}
+		}
+		if colMap[i] != i {
+			identity = false
+		}
+	}
+
+	encodeRecord := func(values []string, base *csvRecord, header bool) string {
+		cells := make([]string, len(values))
+		for i, v := range values {
+			j := -1
+			if i < len(colMap) {
+				j = colMap[i]
+			}
+			hasBase := base != nil && base.raw != nil && j >= 0 && j < len(base.raw)
+			if hasBase && base.fields[j] == v {
+				cells[i] = base.raw[j]
+				continue
+			}
+			quote := false
+			if hasBase {
+				quote = isQuoted(base.raw[j])
+			} else if !header && j >= 0 && j < len(l.quoted) {
+				quote = l.quoted[j]
+			}
+			cells[i] = encodeField(v, delim, quote)
+		}
+		return strings.Join(cells, string(delim))
+	}
+
+	var lines []string
+
+	if identity && l.header.raw != nil {
+		lines = append(lines, l.header.text)
+	} else {
+		lines = append(lines, encodeRecord(file.Headers, &l.header, true))
+	}
+
+	// index original records by value so unchanged rows can be found after
+	// rows are inserted, deleted, or sorted
+	byValue := make(map[string][]int)
+	if identity {
+		for i, r := range l.records {
+			k := strings.Join(r.fields, "\x00")
+			byValue[k] = append(byValue[k], i)
+		}
+	}
+	used := make([]bool, len(l.records))
+
+	for i, row := range file.Rows {
+		if identity {
+			k := strings.Join(row, "\x00")
+			found := -1
+			for _, j := range byValue[k] {
+				if !used[j] {
+					found = j
+					break
+				}
+			}
+			if found >= 0 {
+				used[found] = true
+				lines = append(lines, l.records[found].text)
+				continue
+			}
+		}
+
+		var base *csvRecord
+		if i < len(l.records) {
+			base = &l.records[i]
+		}
+		lines = append(lines, encodeRecord(row, base, false))
+	}
+
+	out := strings.Join(lines, l.lineEnding)
+	if l.trailingNewline {
+		out += l.lineEnding
+	}
+
+	return out
+}
+
+// sortCSVRows sorts rows by IPN, then by Priority. The sort is stable, so
+// rows with the same IPN and priority keep their order.
+func sortCSVRows(file *CSVFile) {
+	ipnIdx := findColumn(file.Headers, "IPN")
+	if ipnIdx < 0 {
+		return
+	}
+	priorityIdx := findColumn(file.Headers, "Priority")
+
+	cell := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	sort.SliceStable(file.Rows, func(i, j int) bool {
+		a, b := cell(file.Rows[i], ipnIdx), cell(file.Rows[j], ipnIdx)
+		if a != b {
+			return a < b
+		}
+		pa, _ := strconv.Atoi(strings.TrimSpace(cell(file.Rows[i], priorityIdx)))
+		pb, _ := strconv.Atoi(strings.TrimSpace(cell(file.Rows[j], priorityIdx)))
+		return pa < pb
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveCSVFileMinimalDiff(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		in       string
+		edit     func(f *CSVFile)
+		expected string
+	}{
+		{"unchanged",
+			"IPN,Description,MPN\r\n\"CAP-001-1001\",\"cap, 10uF\",abc\r\nRES-002-0001,res,\r\n",
+			func(f *CSVFile) {},
+			"IPN,Description,MPN\r\n\"CAP-001-1001\",\"cap, 10uF\",abc\r\nRES-002-0001,res,\r\n"},
+		{"edit cell",
+			"IPN,Description,MPN\n\"CAP-001-1001\",\"cap\",abc\nRES-002-0001,res,def",
+			func(f *CSVFile) { f.Rows[0][1] = "big cap"; f.Rows[1][2] = "xyz" },
+			"IPN,Description,MPN\n\"CAP-001-1001\",\"big cap\",abc\nRES-002-0001,res,xyz"},
+		{"short row kept",
+			"IPN,Description,MPN\nCAP-001-1001,cap\nRES-002-0001,res,def\n",
+			func(f *CSVFile) { f.Rows[1][1] = "resistor" },
+			"IPN,Description,MPN\nCAP-001-1001,cap\nRES-002-0001,resistor,def\n"},
+		{"append row",
+			"\"IPN\",\"MPN\"\n\"CAP-001-1001\",\"abc\"\n",
+			func(f *CSVFile) { f.Rows = append(f.Rows, []string{"CAP-001-1002", "def"}) },
+			"\"IPN\",\"MPN\"\n\"CAP-001-1001\",\"abc\"\n\"CAP-001-1002\",\"def\"\n"},
+		{"delete row",
+			"IPN,MPN\nCAP-001-1001, abc\nCAP-001-1002,def\nCAP-001-1003,ghi\n",
+			func(f *CSVFile) { f.Rows = append(f.Rows[:1], f.Rows[2:]...) },
+			"IPN,MPN\nCAP-001-1001, abc\nCAP-001-1003,ghi\n"},
+		{"add column",
+			"IPN,MPN\nCAP-001-1001,abc\n",
+			func(f *CSVFile) {
+				f.Headers = append(f.Headers, "Note")
+				f.Rows[0] = append(f.Rows[0], "a \"b\"")
+			},
+			"IPN,MPN,Note\nCAP-001-1001,abc,\"a \"\"b\"\"\"\n"},
+	}
+
+	for _, test := range tests {
+		p := filepath.Join(dir, test.name+".csv")
+		if err := os.WriteFile(p, []byte(test.in), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := loadCSVRaw(p)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		test.edit(f)
+
+		if err := saveCSVFile(f); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != test.expected {
+			t.Errorf("%v: expected:\n%q\ngot:\n%q", test.name, test.expected, data)
+		}
+	}
+}
+
+func TestSaveCSVKeepsColumnOrder(t *testing.T) {
+	initCSV()
+	p := filepath.Join(t.TempDir(), "bom.csv")
+	in := "Designator,IPN,Quantity,Note\n\"C1 C2\",CAP-001-1001,2,x\nR1,RES-002-0001,1,\n"
+	if err := os.WriteFile(p, []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := bom{}
+	if err := loadCSV(p, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	b[1].Qty = 3
+	if err := saveCSV(p, b); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := loadCSVRaw(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Headers[0] != "Designator" || f.Headers[1] != "IPN" || f.Headers[2] != "Quantity" || f.Headers[3] != "Note" {
+		t.Fatalf("column order changed: %v", f.Headers)
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "Designator,IPN,Quantity,Note\n\"C1 C2\",CAP-001-1001,2,x\nR1,RES-002-0001,3,\n"
+	if string(data) != exp {
+		t.Errorf("expected:\n%q\ngot:\n%q", exp, data)
+	}
+}
+
+func TestSortCSVRows(t *testing.T) {
+	f := &CSVFile{
+		Headers: []string{"IPN", "MPN", "Priority"},
+		Rows: [][]string{
+			{"RES-002-0001", "a", "1"},
+			{"CAP-001-1001", "b", "2"},
+			{"CAP-001-1001", "c", "1"},
+			{"CAP-001-1001", "d", ""},
+		},
+	}
+
+	sortCSVRows(f)
+
+	exp := []string{"d", "c", "b", "a"}
+	for i, e := range exp {
+		if f.Rows[i][1] != e {
+			t.Fatalf("wrong order: %v", f.Rows)
+		}
+	}
+}
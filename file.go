@@ -1,102 +1,226 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
+	"path/filepath"
 
 	"github.com/gocarina/gocsv"
 )
 
-// load CSV into target data structure. target is modified
+// load CSV into target data structure. target is modified. The delimiter,
+// encoding, and byte order mark are detected from the file. Headers are
+// matched to the target fields ignoring case, and using the column aliases.
+// Columns that do not match a field are kept in the Extra field of the target
+// elements, if they have one.
 func loadCSV(fileName string, target any) error {
-	file, err := os.OpenFile(fileName, os.O_RDONLY, 0644)
+	data, d, err := readCSVFile(fileName)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	return gocsv.UnmarshalFile(file, target)
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = d.Delimiter
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	if len(records) > 0 {
+		records[0] = mapColumns(records[0], csvColumns(target))
+	}
+
+	err = gocsv.UnmarshalCSV(&recordReader{records: records}, target)
+	if err != nil {
+		return err
+	}
+
+	setExtraColumns(target, records)
+	return nil
 }
 
+// saveCSV writes data to a CSV file, including any Extra columns. If the file
+// exists, it is written in the same dialect and column order it is currently
+// in, and rows that have not changed are not modified.
 func saveCSV(filename string, data any) error {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	var buf bytes.Buffer
+	err := gocsv.MarshalCSV(data, gocsv.NewSafeCSVWriter(csv.NewWriter(&buf)))
+	if err != nil {
+		return err
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	return gocsv.MarshalFile(data, file)
+	records = addExtraColumns(records, data)
+	if len(records) == 0 {
+		return fmt.Errorf("no CSV header for %v", filename)
+	}
+
+	file := &CSVFile{
+		Name: filepath.Base(filename),
+		Path: filename,
+	}
+
+	if existing, err := loadCSVRaw(filename); err == nil {
+		file = existing
+		records = orderColumns(records, file.Headers)
+	} else {
+		file.Dialect = csvDialectForWrite(filename)
+	}
+
+	file.Headers = records[0]
+	file.Rows = records[1:]
+
+	return saveCSVFile(file)
 }
 
-// findDir recursively searches the directory tree for a directory name. This skips soft links.
-func findDir(name string) (string, error) {
-	retPath := ""
-	// WalkDir does not follown symbolic links
-	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+// orderColumns reorders the columns of records to match the order of headers
+// in an existing file. Columns matched by alias keep the existing header name.
+// Columns that are not in the existing file are added at the end, unless all
+// their values are empty.
+func orderColumns(records [][]string, headers []string) [][]string {
+	var order []int
+	used := make(map[int]bool)
+	var names []string
+
+	for _, h := range headers {
+		idx := -1
+		for i, c := range records[0] {
+			if !used[i] && c == h {
+				idx = i
+				break
+			}
 		}
-		if d.IsDir() {
-			if name == d.Name() {
-				// found it
-				retPath = path
+		if idx < 0 {
+			for i, c := range records[0] {
+				if !used[i] && matchColumn(h, c) {
+					idx = i
+					break
+				}
 			}
 		}
-		return nil
-	})
+		if idx >= 0 {
+			used[idx] = true
+			order = append(order, idx)
+			names = append(names, h)
+		}
+	}
 
-	if err != nil {
-		return "", err
+	for i, c := range records[0] {
+		if used[i] {
+			continue
+		}
+		for _, rec := range records[1:] {
+			if i < len(rec) && rec[i] != "" {
+				order = append(order, i)
+				names = append(names, c)
+				break
+			}
+		}
 	}
 
-	if retPath == "" {
-		return retPath, fmt.Errorf("Dir not found: %v", name)
+	out := [][]string{names}
+	for _, rec := range records[1:] {
+		row := make([]string, len(order))
+		for i, idx := range order {
+			if idx < len(rec) {
+				row[i] = rec[idx]
+			}
+		}
+		out = append(out, row)
 	}
 
-	return retPath, nil
+	return out
+}
+
+// findDir searches the workspace for a directory name. This skips soft links.
+func findDir(name string) (string, error) {
+	ws, err := getWorkspace()
+	if err != nil {
+		return "", err
+	}
+	return ws.findDir(name)
 }
 
-// findFile recursively searches the directory tree to find a file and returns the path
+// findFile searches the workspace to find a file and returns the path
 func findFile(name string) (string, error) {
-	retPath := ""
-	// WalkDir does not follown symbolic links
-	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			if name == d.Name() {
-				// found it
-				retPath = path
-			}
-		}
-		return nil
-	})
+	ws, err := getWorkspace()
+	if err != nil {
+		return "", err
+	}
+	return ws.findFile(name)
+}
+
+// findSourceFile searches the workspace for a source file, skipping release
+// directories, and returns the path
+func findSourceFile(name string) (string, error) {
+	ws, err := getWorkspace()
+	if err != nil {
+		return "", err
+	}
+	return ws.findSourceFile(name)
+}
 
+// findBomFile searches the workspace for the BOM of a full IPN, preferring
+// one in a source directory over one in a release directory
+func findBomFile(name string) (string, error) {
+	ws, err := getWorkspace()
 	if err != nil {
 		return "", err
 	}
+	return ws.findBomFile(name)
+}
+
+// csvDelimiter is the default field delimiter for CSV files
+var csvDelimiter = ','
+
+// parseCSVDelimiter parses a delimiter from a config string. A blank string
+// returns the default delimiter.
+func parseCSVDelimiter(d string) (rune, error) {
+	if d == "" {
+		return csvDelimiter, nil
+	}
 
-	if retPath == "" {
-		return retPath, fmt.Errorf("File not found: %v", name)
+	if d == `\t` {
+		return '\t', nil
+	}
+
+	r := []rune(d)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+		return 0, fmt.Errorf("invalid CSV delimiter: %q", d)
+	}
+
+	return r[0], nil
+}
+
+// setCSVDelimiter sets the default CSV field delimiter from a config string
+func setCSVDelimiter(d string) error {
+	r, err := parseCSVDelimiter(d)
+	if err != nil {
+		return err
 	}
 
-	return retPath, nil
+	csvDelimiter = r
+	return nil
 }
 
 func initCSV() {
 	gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
 		r := csv.NewReader(in)
-		r.Comma = ','
+		r.Comma = csvDelimiter
 		return r
 	})
 
 	gocsv.SetCSVWriter(func(out io.Writer) *gocsv.SafeCSVWriter {
 		writer := csv.NewWriter(out)
-		writer.Comma = ','
+		writer.Comma = csvDelimiter
 		return gocsv.NewSafeCSVWriter(writer)
 	})
 }
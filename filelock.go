@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// lockFileName is the name of the lock file created in a directory while
+// files in it are written
+const lockFileName = ".gitplm.lock"
+
+var (
+	// lockTimeout is how long to wait for another writer to release a lock
+	lockTimeout = 10 * time.Second
+	// lockStale is the age after which a lock file is assumed to be left over
+	// from a process that crashed and is removed
+	lockStale = 2 * time.Minute
+)
+
+// lockDir takes an advisory lock on a directory by creating a lock file in
+// it. The returned function releases the lock.
+func lockDir(dir string) (func(), error) {
+	lockPath := filepath.Join(dir, lockFileName)
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			f.Close()
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return func() { os.Remove(lockPath) }, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("error creating lock file %v: %v", lockPath, err)
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for lock %v", lockPath)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it over filePath, so readers never see a partially written file.
+// The permissions of an existing file are kept.
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(filePath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// fileState identifies the contents of a file when it was read
+type fileState struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// newFileState returns the state of a file read from disk
+func newFileState(filePath string, data []byte) fileState {
+	s := fileState{size: int64(len(data)), hash: sha256.Sum256(data)}
+	if info, err := os.Stat(filePath); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return s
+}
+
+// changed returns true if the file on disk is not the file that was read. The
+// modification time is checked first, and the contents are only hashed if it
+// changed.
+func (s fileState) changed(filePath string) (bool, error) {
+	info, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	hash := sha256.Sum256(data)
+	return !bytes.Equal(hash[:], s.hash[:]), nil
+}
+
+// conflictError is returned when a file was modified on disk after it was
+// loaded
+type conflictError struct {
+	path string
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("%v was modified on disk since it was loaded", e.path)
+}
+
+// isConflict returns true if err is a conflictError
+func isConflict(err error) bool {
+	var c *conflictError
+	return errors.As(err, &c)
+}
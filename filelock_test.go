@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveCSVFileConflict(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "cap.csv")
+	if err := os.WriteFile(p, []byte("IPN,MPN\nCAP-001-1001,abc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := loadCSVRaw(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Rows[0][1] = "def"
+	if err := saveCSVFile(f); err != nil {
+		t.Fatalf("error saving: %v", err)
+	}
+
+	// saving again after our own save is not a conflict
+	f.Rows[0][1] = "ghi"
+	if err := saveCSVFile(f); err != nil {
+		t.Fatalf("error saving twice: %v", err)
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("file mode changed to %v", info.Mode().Perm())
+	}
+
+	// another editor changes the file
+	if err := os.WriteFile(p, []byte("IPN,MPN\nCAP-001-1001,xyz\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Rows[0][1] = "jkl"
+	err = saveCSVFile(f)
+	if !isConflict(err) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "IPN,MPN\nCAP-001-1001,xyz\n" {
+		t.Errorf("file was overwritten: %q", data)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("lock or temp files left behind: %v", entries)
+	}
+}
+
+func TestLockDir(t *testing.T) {
+	dir := t.TempDir()
+
+	unlock, err := lockDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 100 * time.Millisecond
+
+	if _, err := lockDir(dir); err == nil {
+		t.Fatal("expected timeout while locked")
+	}
+
+	unlock()
+
+	unlock, err = lockDir(dir)
+	if err != nil {
+		t.Fatalf("error locking after unlock: %v", err)
+	}
+	unlock()
+
+	// stale locks are removed
+	lockPath := filepath.Join(dir, lockFileName)
+	if err := os.WriteFile(lockPath, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStale)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err = lockDir(dir)
+	if err != nil {
+		t.Fatalf("stale lock not removed: %v", err)
+	}
+	unlock()
+}
@@ -42,18 +42,11 @@ function App() {
       .then(res => {
         const detail = res.data;
         setSelectedPart(detail);
-        setDescription(detail.fields?.Description?.value || '');
-        const srcs = [];
-        let idx = 1;
-        while (true) {
-          const mKey = idx === 1 ? 'Manufacturer' : `Manufacturer${idx}`;
-          const pKey = idx === 1 ? 'MPN' : `MPN${idx}`;
-          const m = detail.fields?.[mKey]?.value;
-          const p = detail.fields?.[pKey]?.value;
-          if (!m && !p) break;
-          srcs.push({ manufacturer: m || '', mpn: p || '' });
-          idx++;
-        }
+        setDescription(detail.name || '');
+        const srcs = (detail.sources || []).map(src => ({
+          manufacturer: src.manufacturer || '',
+          mpn: src.mpn || '',
+        }));
         if (srcs.length === 0) srcs.push({ manufacturer: '', mpn: '' });
         setSources(srcs);
         setRevision(detail.revision || '');
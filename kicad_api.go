@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/samber/lo"
 )
@@ -39,20 +47,34 @@ type KiCadPartDetail struct {
 	ExcludeFromBOM string                    `json:"exclude_from_bom,omitempty"`
 	Fields         map[string]KiCadPartField `json:"fields,omitempty"`
 	Revision       string                    `json:"revision,omitempty"`
+	Sources        []PartSource              `json:"sources,omitempty"`
 }
 
-// PartSource represents a manufacturer/MPN pair for a part
+// PartSource represents a manufacturer/MPN pair for a part. Each source is a
+// row in the partmaster, and sources with a lower priority are preferred.
 type PartSource struct {
 	Manufacturer string `json:"manufacturer"`
 	MPN          string `json:"mpn"`
+	Priority     int    `json:"priority,omitempty"`
 }
 
-// PartUpdateRequest captures fields that can be updated for a part
+// PartUpdateRequest captures fields that can be updated for a part. If Sources
+// is set, it replaces the sources of the part, most preferred first, and the
+// priority of each source is set from its position.
 type PartUpdateRequest struct {
 	Description string       `json:"description"`
 	Sources     []PartSource `json:"sources"`
 }
 
+// PartCreateRequest describes a new part. If ID is blank, the next free IPN in
+// the category is allocated.
+type PartCreateRequest struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Category string            `json:"category"`
+	Fields   map[string]string `json:"fields"`
+}
+
 // KiCadPartField represents a field in a KiCad part
 type KiCadPartField struct {
 	Value   string `json:"value"`
@@ -65,18 +87,50 @@ type KiCadRootResponse struct {
 	Parts      string `json:"parts"`
 }
 
+const (
+	// lifecycleColumn is the partmaster column holding the lifecycle state
+	// of a part
+	lifecycleColumn = "Lifecycle"
+	// lifecycleObsolete marks parts that should not be used in new designs
+	lifecycleObsolete = "Obsolete"
+)
+
 // KiCadServer represents the KiCad HTTP API server
 type KiCadServer struct {
-	pmDir         string
-	csvCollection *CSVFileCollection
-	token         string
+	pmDir  string
+	config *Config
+	// users can access the server. If there are none, authentication is not
+	// required.
+	users []*serverUser
+	// snapshot is the loaded CSV collection. A snapshot is replaced as a
+	// whole when the files are reloaded, so a request that loads it once sees
+	// a consistent partmaster.
+	snapshot atomic.Pointer[CSVFileCollection]
+	// cache indexes the CSV collection when the SQLite cache is enabled
+	cache *partCache
+	// cacheMu is held for writing while the cache is synced and the snapshot
+	// replaced, so cache queries return rows of the current snapshot
+	cacheMu sync.RWMutex
+	// writeMu serializes edits and reloads. Edits are made to copies of the
+	// files in the snapshot, which are saved and then reloaded into a new
+	// snapshot, so readers never see a partial edit.
+	writeMu sync.Mutex
 }
 
-// NewKiCadServer creates a new KiCad HTTP API server
+// NewKiCadServer creates a new KiCad HTTP API server. If token is set, it is
+// accepted as the token of an admin user.
 func NewKiCadServer(pmDir, token string) (*KiCadServer, error) {
 	server := &KiCadServer{
-		pmDir: pmDir,
-		token: token,
+		pmDir:  pmDir,
+		config: &Config{},
+	}
+
+	if token != "" {
+		user := &serverUser{Name: "token", Token: token, Role: "admin"}
+		if err := user.init(); err != nil {
+			return nil, err
+		}
+		server.users = append(server.users, user)
 	}
 
 	// Load CSV collection data
@@ -87,7 +141,14 @@ func NewKiCadServer(pmDir, token string) (*KiCadServer, error) {
 	return server, nil
 }
 
+// collection returns the current snapshot of the CSV collection
+func (s *KiCadServer) collection() *CSVFileCollection {
+	return s.snapshot.Load()
+}
+
 // loadCSVCollection loads the CSV collection from the configured directory
+// when the server starts. If there are no CSV files, a blank partmaster is
+// created so the server can start.
 func (s *KiCadServer) loadCSVCollection() error {
 	if s.pmDir == "" {
 		return fmt.Errorf("partmaster directory not configured")
@@ -103,19 +164,112 @@ func (s *KiCadServer) loadCSVCollection() error {
 		collection = &CSVFileCollection{Files: []*CSVFile{csvFile}}
 	}
 
-	s.csvCollection = collection
+	return s.storeCollection(collection)
+}
+
+// readCSVCollection reads the CSV files again after they were changed by an
+// edit or by others. Files are never created, and if no CSV file can be loaded,
+// for example while another program is writing one, the current snapshot is
+// kept.
+func (s *KiCadServer) readCSVCollection() error {
+	collection, err := readCSVFiles(s.pmDir)
+	if err != nil {
+		return err
+	}
+	if len(collection.Files) == 0 {
+		return fmt.Errorf("no CSV files could be loaded from %v, keeping the loaded files", s.pmDir)
+	}
+
+	return s.storeCollection(collection)
+}
+
+// storeCollection updates the cache from a loaded collection, and replaces
+// the snapshot with it
+func (s *KiCadServer) storeCollection(collection *CSVFileCollection) error {
+	if s.cache != nil {
+		s.cacheMu.Lock()
+		defer s.cacheMu.Unlock()
+		if err := s.cache.sync(collection); err != nil {
+			return fmt.Errorf("failed to update cache: %w", err)
+		}
+	}
+
+	s.snapshot.Store(collection)
+
 	return nil
 }
 
-// authenticate checks if the request has a valid token
-func (s *KiCadServer) authenticate(r *http.Request) bool {
-	if s.token == "" {
-		return true // No authentication required if no token set
+// reloadCSVCollection reloads the CSV collection when files are changed by
+// others
+func (s *KiCadServer) reloadCSVCollection() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.readCSVCollection()
+}
+
+// editRows returns the rows in copies of their files that can be edited
+// without changing the snapshot. Rows in the same file share a copy.
+func editRows(rows []cachedRow) []cachedRow {
+	copies := make(map[*CSVFile]*CSVFile)
+	ret := make([]cachedRow, len(rows))
+	for i, r := range rows {
+		c := copies[r.file]
+		if c == nil {
+			c = r.file.clone()
+			copies[r.file] = c
+		}
+		ret[i] = cachedRow{c, r.row}
 	}
+	return ret
+}
 
-	auth := r.Header.Get("Authorization")
-	expectedAuth := "Token " + s.token
-	return auth == expectedAuth
+// enableCache opens the SQLite cache configured for the server
+func (s *KiCadServer) enableCache() error {
+	cache, err := loadPartCache(s.config, s.collection())
+	if err != nil {
+		return err
+	}
+	s.cache = cache
+	return nil
+}
+
+// cacheQuery runs a query on the cache and returns the rows it refers to. ok
+// is false if the cache is not enabled or the query failed.
+func (s *KiCadServer) cacheQuery(query func(c *partCache) ([]partRef, error)) (rows []cachedRow, ok bool) {
+	if s.cache == nil {
+		return nil, false
+	}
+
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+
+	refs, err := query(s.cache)
+	if err != nil {
+		log.Printf("Error querying cache: %v", err)
+		return nil, false
+	}
+	return s.cachedRows(refs), true
+}
+
+// cachedRows returns the rows for refs from the cache. Refs to files or rows
+// that are not loaded are skipped.
+func (s *KiCadServer) cachedRows(refs []partRef) []cachedRow {
+	collection := s.collection()
+	var rows []cachedRow
+	for _, r := range refs {
+		file := collection.file(r.File)
+		if file == nil || r.Row >= len(file.Rows) {
+			continue
+		}
+		rows = append(rows, cachedRow{file, r.Row})
+	}
+	return rows
+}
+
+// cachedRow is a row in a file of the CSV collection
+type cachedRow struct {
+	file *CSVFile
+	row  int
 }
 
 // getCategories extracts unique categories from the CSV collection
@@ -123,7 +277,7 @@ func (s *KiCadServer) getCategories() []KiCadCategory {
 	categoryMap := make(map[string]bool)
 
 	// Extract categories from CSV files and IPNs
-	for _, file := range s.csvCollection.Files {
+	for _, file := range s.collection().Files {
 		// Try to extract category from filename (e.g., cap.csv -> CAP)
 		if fileName := strings.TrimSuffix(strings.ToUpper(file.Name), ".CSV"); fileName != "" && len(fileName) == 3 {
 			categoryMap[fileName] = true
@@ -158,19 +312,23 @@ func (s *KiCadServer) getCategories() []KiCadCategory {
 	return categories
 }
 
-// findColumnIndex finds the index of a column by name in a CSV file
+// findColumnIndex finds the index of a column by name or alias in a CSV file
 func (s *KiCadServer) findColumnIndex(file *CSVFile, columnName string) int {
-	for i, header := range file.Headers {
-		if header == columnName {
-			return i
-		}
-	}
-	return -1
+	return findColumn(file.Headers, columnName)
 }
 
 // findPart locates a part by IPN and returns its file and row index
 func (s *KiCadServer) findPart(partID string) (*CSVFile, int) {
-	for _, file := range s.csvCollection.Files {
+	if rows, ok := s.cacheQuery(func(c *partCache) ([]partRef, error) {
+		return c.findIPN(partID)
+	}); ok {
+		for _, r := range rows {
+			return r.file, r.row
+		}
+		return nil, -1
+	}
+
+	for _, file := range s.collection().Files {
 		ipnIdx := s.findColumnIndex(file, "IPN")
 		for i, row := range file.Rows {
 			if ipnIdx >= 0 && len(row) > ipnIdx && row[ipnIdx] == partID {
@@ -181,6 +339,69 @@ func (s *KiCadServer) findPart(partID string) (*CSVFile, int) {
 	return nil, -1
 }
 
+// findPartRows returns all rows for an IPN. A part has one row per source.
+func (s *KiCadServer) findPartRows(partID string) []cachedRow {
+	if rows, ok := s.cacheQuery(func(c *partCache) ([]partRef, error) {
+		return c.findIPN(partID)
+	}); ok {
+		return rows
+	}
+
+	var rows []cachedRow
+	for _, file := range s.collection().Files {
+		ipnIdx := s.findColumnIndex(file, "IPN")
+		for i, row := range file.Rows {
+			if ipnIdx >= 0 && len(row) > ipnIdx && row[ipnIdx] == partID {
+				rows = append(rows, cachedRow{file, i})
+			}
+		}
+	}
+	return rows
+}
+
+// partRows returns all rows for an IPN sorted by priority, so the preferred
+// source is first
+func (s *KiCadServer) partRows(partID string) []cachedRow {
+	rows := s.findPartRows(partID)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return s.rowPriority(rows[i]) < s.rowPriority(rows[j])
+	})
+	return rows
+}
+
+// rowPriority returns the priority of a row, or 0 if it has none
+func (s *KiCadServer) rowPriority(r cachedRow) int {
+	var priority int
+	fmt.Sscanf(s.cell(r.file, r.file.Rows[r.row], "Priority"), "%d", &priority)
+	return priority
+}
+
+// cell returns the value of a column in a row, or "" if there is no such
+// column
+func (s *KiCadServer) cell(file *CSVFile, row []string, column string) string {
+	idx := s.findColumnIndex(file, column)
+	if idx < 0 || idx >= len(row) {
+		return ""
+	}
+	return row[idx]
+}
+
+// setCell sets the value of a column in a row, adding the column to the file
+// if needed
+func (s *KiCadServer) setCell(file *CSVFile, row int, column, value string) {
+	idx := s.ensureColumn(file, column)
+	if len(file.Rows[row]) <= idx {
+		file.Rows[row] = append(file.Rows[row], make([]string, idx+1-len(file.Rows[row]))...)
+	}
+	file.Rows[row][idx] = value
+}
+
+// isObsolete returns true if the lifecycle column of a row is obsolete
+func (s *KiCadServer) isObsolete(file *CSVFile, row []string) bool {
+	idx := s.findColumnIndex(file, lifecycleColumn)
+	return idx >= 0 && idx < len(row) && strings.EqualFold(strings.TrimSpace(row[idx]), lifecycleObsolete)
+}
+
 // ensureColumn makes sure a column exists in the file and returns its index
 func (s *KiCadServer) ensureColumn(file *CSVFile, header string) int {
 	idx := s.findColumnIndex(file, header)
@@ -217,6 +438,10 @@ func (s *KiCadServer) extractRevision(ipnStr string) string {
 
 // getCategoryDisplayName returns a human-readable name for a category
 func (s *KiCadServer) getCategoryDisplayName(category string) string {
+	if c := s.config.category(category); c != nil && c.Name != "" {
+		return c.Name
+	}
+
 	displayNames := map[string]string{
 		"CAP": "Capacitors",
 		"RES": "Resistors",
@@ -258,6 +483,10 @@ func (s *KiCadServer) getCategoryDisplayName(category string) string {
 
 // getCategoryDescription returns a description for a category
 func (s *KiCadServer) getCategoryDescription(category string) string {
+	if c := s.config.category(category); c != nil && c.Description != "" {
+		return c.Description
+	}
+
 	descriptions := map[string]string{
 		"CAP": "Capacitor components",
 		"RES": "Resistor components",
@@ -297,11 +526,25 @@ func (s *KiCadServer) getCategoryDescription(category string) string {
 	return fmt.Sprintf("%s components", category)
 }
 
-// getPartsByCategory returns parts filtered by category
+// getPartsByCategory returns parts filtered by category. Obsolete parts are
+// not included.
 func (s *KiCadServer) getPartsByCategory(categoryID string) []KiCadPartSummary {
 	var parts []KiCadPartSummary
 
-	for _, file := range s.csvCollection.Files {
+	if rows, ok := s.cacheQuery(func(c *partCache) ([]partRef, error) {
+		return c.byCategory(categoryID)
+	}); ok {
+		for _, r := range rows {
+			row := r.file.Rows[r.row]
+			if s.isObsolete(r.file, row) {
+				continue
+			}
+			parts = append(parts, s.partSummary(r.file, row, categoryID, len(parts)))
+		}
+		return parts
+	}
+
+	for _, file := range s.collection().Files {
 		// Check if this file belongs to the category
 		fileName := strings.TrimSuffix(strings.ToUpper(file.Name), ".CSV")
 		fileCategory := ""
@@ -313,7 +556,6 @@ func (s *KiCadServer) getPartsByCategory(categoryID string) []KiCadPartSummary {
 
 		// Check parts within this file
 		ipnIdx := s.findColumnIndex(file, "IPN")
-		descIdx := s.findColumnIndex(file, "Description")
 
 		for _, row := range file.Rows {
 			if len(row) == 0 {
@@ -326,30 +568,10 @@ func (s *KiCadServer) getPartsByCategory(categoryID string) []KiCadPartSummary {
 				partCategory = s.extractCategory(row[ipnIdx])
 			}
 
-			// Include if category matches
-			if partCategory == categoryID {
-				partID := ""
-				partName := ""
-				partDesc := ""
-
-				// Get part ID (prefer IPN, fallback to row index)
-				if ipnIdx >= 0 && len(row) > ipnIdx && row[ipnIdx] != "" {
-					partID = row[ipnIdx]
-				} else {
-					partID = fmt.Sprintf("%s-unknown-%d", categoryID, len(parts))
-				}
-
-				// Get description
-				if descIdx >= 0 && len(row) > descIdx {
-					partName = row[descIdx]
-					partDesc = row[descIdx]
-				}
-
-				parts = append(parts, KiCadPartSummary{
-					ID:          partID,
-					Name:        partName,
-					Description: partDesc,
-				})
+			// Include if category matches. Obsolete parts are not offered
+			// for new designs.
+			if partCategory == categoryID && !s.isObsolete(file, row) {
+				parts = append(parts, s.partSummary(file, row, categoryID, len(parts)))
 			}
 		}
 	}
@@ -357,107 +579,497 @@ func (s *KiCadServer) getPartsByCategory(categoryID string) []KiCadPartSummary {
 	return parts
 }
 
-// getPartDetail returns detailed information for a specific part
+// partSummary returns the summary for a row. n is the number of parts already
+// listed, and is used to create an ID for rows without an IPN.
+func (s *KiCadServer) partSummary(file *CSVFile, row []string, categoryID string, n int) KiCadPartSummary {
+	ipnIdx := s.findColumnIndex(file, "IPN")
+	descIdx := s.findColumnIndex(file, "Description")
+
+	partID := ""
+	partName := ""
+	partDesc := ""
+
+	// Get part ID (prefer IPN, fallback to row index)
+	if ipnIdx >= 0 && len(row) > ipnIdx && row[ipnIdx] != "" {
+		partID = row[ipnIdx]
+	} else {
+		partID = fmt.Sprintf("%s-unknown-%d", categoryID, n)
+	}
+
+	// Get description
+	if descIdx >= 0 && len(row) > descIdx {
+		partName = row[descIdx]
+		partDesc = row[descIdx]
+	}
+
+	return KiCadPartSummary{
+		ID:          partID,
+		Name:        partName,
+		Description: partDesc,
+	}
+}
+
+// getPartDetail returns detailed information for a specific part. Fields are
+// taken from the preferred source, and blank description, footprint, and value
+// columns are filled in from the other sources. Columns are mapped to KiCad
+// fields with kicadFields. The Symbol column overrides the symbol of the
+// category, and the ExcludeFromBOM column excludes the part from KiCad BOMs.
 func (s *KiCadServer) getPartDetail(partID string) *KiCadPartDetail {
-	for _, file := range s.csvCollection.Files {
-		ipnIdx := s.findColumnIndex(file, "IPN")
+	rows := s.partRows(partID)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	file := rows[0].file
+	row := make([]string, max(len(file.Headers), len(file.Rows[rows[0].row])))
+	copy(row, file.Rows[rows[0].row])
+	category := s.extractCategory(partID)
+
+	for _, column := range []string{"Description", "Footprint", "Value"} {
+		idx := s.findColumnIndex(file, column)
+		if idx < 0 || row[idx] != "" {
+			continue
+		}
+		for _, r := range rows[1:] {
+			if v := s.cell(r.file, r.file.Rows[r.row], column); v != "" {
+				row[idx] = v
+				break
+			}
+		}
+	}
+
+	symbol := s.cell(file, row, symbolColumn)
+	if symbol == "" {
+		symbol = s.getSymbolIDFromCategory(category)
+	}
+
+	var sources []PartSource
+	for _, r := range rows {
+		sources = append(sources, PartSource{
+			Manufacturer: s.cell(r.file, r.file.Rows[r.row], "Manufacturer"),
+			MPN:          s.cell(r.file, r.file.Rows[r.row], "MPN"),
+			Priority:     s.rowPriority(r),
+		})
+	}
+
+	return &KiCadPartDetail{
+		ID:             partID,
+		Name:           s.cell(file, row, "Description"),
+		SymbolIDStr:    symbol,
+		ExcludeFromBOM: kicadBool(isTrue(s.cell(file, row, excludeFromBOMColumn))),
+		Fields:         s.kicadFields(category, file, row),
+		Revision:       s.extractRevision(partID),
+		Sources:        sources,
+	}
+}
+
+// updatePart updates editable fields for a part and saves to disk. The
+// description is set on the preferred source, and on other sources that have
+// one.
+func (s *KiCadServer) updatePart(user *serverUser, partID string, req PartUpdateRequest) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	rows := editRows(s.partRows(partID))
+	if len(rows) == 0 {
+		return &apiError{http.StatusNotFound, "part not found"}
+	}
+	if req.Sources != nil && len(req.Sources) == 0 {
+		return &apiError{http.StatusBadRequest, "a part must have at least one source"}
+	}
+
+	files := rowFiles(rows)
+	before := rowValues(rows)
+
+	if req.Description != "" {
+		for i, r := range rows {
+			if i == 0 || s.cell(r.file, r.file.Rows[r.row], "Description") != "" {
+				s.setCell(r.file, r.row, "Description", req.Description)
+			}
+		}
+	}
+
+	if req.Sources != nil {
+		s.updateSources(rows, req.Sources)
+	}
+
+	if err := s.saveCSVFiles(files); err != nil {
+		return err
+	}
+
+	s.recordChange(partChange{user: user, action: "update", part: partID,
+		before: before, after: rowValues(s.partRows(partID)), files: files})
+	return nil
+}
+
+// sourceColumns are the columns that describe one source of a part. They are
+// cleared when a row is added for a new source.
+var sourceColumns = []string{"Manufacturer", "MPN", "Datasheet", "Priority", "Checked"}
+
+// updateSources replaces the sources of a part, which has one row per source.
+// Sources are matched to existing rows by manufacturer and MPN, so the other
+// columns of a source are kept when sources are reordered. Remaining sources
+// reuse rows that no longer match, or are added as copies of the preferred
+// row after the last row of the part. Rows that are left over are deleted.
+//
+// Priorities are set from the order of the sources, starting at 1. A part
+// with a single source is only given a priority if it already had one.
+func (s *KiCadServer) updateSources(rows []cachedRow, sources []PartSource) {
+	key := func(mfr, mpn string) string {
+		return strings.ToLower(strings.TrimSpace(mfr)) + "\x00" + strings.ToLower(strings.TrimSpace(mpn))
+	}
+
+	match := make([]int, len(sources))
+	used := make([]bool, len(rows))
+	for i, src := range sources {
+		match[i] = -1
+		for j, r := range rows {
+			row := r.file.Rows[r.row]
+			if !used[j] && key(s.cell(r.file, row, "Manufacturer"), s.cell(r.file, row, "MPN")) ==
+				key(src.Manufacturer, src.MPN) {
+				match[i] = j
+				used[j] = true
+				break
+			}
+		}
+	}
+	for i := range sources {
+		for j := range rows {
+			if match[i] < 0 && !used[j] {
+				match[i] = j
+				used[j] = true
+			}
+		}
+	}
+
+	primary := rows[0]
+	file := primary.file
+	columns := []string{"Manufacturer", "MPN"}
+	if len(sources) > 1 || lo.Contains(match, -1) {
+		// added rows are allocated with the headers, so all columns they use
+		// must exist first
+		columns = append(columns, "Priority")
+	}
+	for _, column := range columns {
+		s.ensureColumn(file, column)
+	}
+
+	var added [][]string
+	for i, src := range sources {
+		if match[i] >= 0 {
+			r := rows[match[i]]
+			s.setCell(r.file, r.row, "Manufacturer", src.Manufacturer)
+			s.setCell(r.file, r.row, "MPN", src.MPN)
+			if len(sources) > 1 || s.cell(r.file, r.file.Rows[r.row], "Priority") != "" {
+				s.setCell(r.file, r.row, "Priority", strconv.Itoa(i+1))
+			}
+			continue
+		}
+
+		row := make([]string, len(file.Headers))
+		copy(row, file.Rows[primary.row])
+		for _, column := range sourceColumns {
+			if idx := s.findColumnIndex(file, column); idx >= 0 {
+				row[idx] = ""
+			}
+		}
+		row[s.findColumnIndex(file, "Manufacturer")] = src.Manufacturer
+		row[s.findColumnIndex(file, "MPN")] = src.MPN
+		row[s.findColumnIndex(file, "Priority")] = strconv.Itoa(i + 1)
+		added = append(added, row)
+	}
+
+	// delete rows that were not reused, and insert the new rows
+	deleted := make(map[*CSVFile]map[int]bool)
+	last := -1
+	for j, r := range rows {
+		if !used[j] {
+			if deleted[r.file] == nil {
+				deleted[r.file] = make(map[int]bool)
+			}
+			deleted[r.file][r.row] = true
+		}
+		if r.file == file && r.row > last {
+			last = r.row
+		}
+	}
+
+	for _, f := range rowFiles(rows) {
+		var out [][]string
+		for i, row := range f.Rows {
+			if !deleted[f][i] {
+				out = append(out, row)
+			}
+			if f == file && i == last {
+				out = append(out, added...)
+			}
+		}
+		f.Rows = out
+	}
+}
+
+// createPart validates or allocates the IPN for a new part and appends it to
+// the CSV file for its category, creating <ccc>.csv if there is none
+func (s *KiCadServer) createPart(user *serverUser, req PartCreateRequest) (*KiCadPartDetail, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	category := strings.ToUpper(strings.TrimSpace(req.Category))
+	id := strings.TrimSpace(req.ID)
+
+	if id != "" {
+		c, _, _, err := ipn(id).parse()
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("invalid IPN %v, expected CCC-NNN-VVVV", id)}
+		}
+		if category != "" && category != c {
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("IPN %v is not in category %v", id, category)}
+		}
+		category = c
+		if file, _ := s.findPart(id); file != nil {
+			return nil, &apiError{http.StatusConflict, fmt.Sprintf("part %v already exists", id)}
+		}
+	} else {
+		if reC.FindString(category) == "" {
+			return nil, &apiError{http.StatusBadRequest, "category must be 3 upper case letters (CCC)"}
+		}
+		var err error
+		id, err = s.allocateIPN(category)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := s.categoryFile(category)
+	if err != nil {
+		return nil, err
+	}
+	file = file.clone()
+
+	file.Rows = append(file.Rows, make([]string, len(file.Headers)))
+	rowIdx := len(file.Rows) - 1
+	set := func(column, value string) {
+		idx := s.ensureColumn(file, column)
+		file.Rows[rowIdx][idx] = value
+	}
+
+	set("IPN", id)
+	if req.Name != "" {
+		set("Description", req.Name)
+	}
 
+	// add fields in a stable order so new columns are always added the same way
+	columns := lo.Keys(req.Fields)
+	sort.Strings(columns)
+	for _, c := range columns {
+		if strings.TrimSpace(c) == "" || matchColumn(c, "IPN") {
+			continue
+		}
+		set(canonicalColumn(strings.TrimSpace(c)), req.Fields[c])
+	}
+
+	if err := s.saveCSVFiles([]*CSVFile{file}); err != nil {
+		return nil, err
+	}
+
+	s.recordChange(partChange{user: user, action: "create", part: id,
+		after: rowValues(s.partRows(id)), files: []*CSVFile{file}})
+	return s.getPartDetail(id), nil
+}
+
+// allocateIPN returns the next unused IPN in a category. The variation of the
+// new IPN is 0000.
+func (s *KiCadServer) allocateIPN(category string) (string, error) {
+	maxN := 0
+	for _, file := range s.collection().Files {
+		ipnIdx := s.findColumnIndex(file, "IPN")
+		if ipnIdx < 0 {
+			continue
+		}
 		for _, row := range file.Rows {
-			if len(row) == 0 {
+			if ipnIdx >= len(row) {
 				continue
 			}
+			c, n, _, err := ipn(row[ipnIdx]).parse()
+			if err == nil && c == category && n > maxN {
+				maxN = n
+			}
+		}
+	}
 
-			// Check if this is the right part
-			rowPartID := ""
-			if ipnIdx >= 0 && len(row) > ipnIdx {
-				rowPartID = row[ipnIdx]
+	newIPN, err := newIpnParts(category, maxN+1, 0)
+	if err != nil {
+		return "", &apiError{http.StatusConflict, fmt.Sprintf("no free IPN in category %v: %v", category, err)}
+	}
+
+	return newIPN.String(), nil
+}
+
+// categoryFile returns the CSV file for a category: the file named for the
+// category (ex: cap.csv for CAP), or else the file that holds parts in the
+// category (ex: passives.csv), so a category is not split across files. If
+// there is none, a new file with the standard partmaster headers is returned.
+func (s *KiCadServer) categoryFile(category string) (*CSVFile, error) {
+	for _, file := range s.collection().Files {
+		if strings.EqualFold(strings.TrimSuffix(file.Name, filepath.Ext(file.Name)), category) {
+			return file, nil
+		}
+	}
+
+	if rows := s.categoryRows(category); len(rows) > 0 {
+		return rows[0].file, nil
+	}
+
+	path := filepath.Join(s.pmDir, strings.ToLower(category)+".csv")
+	if fileExists(path) {
+		return nil, fmt.Errorf("%v exists but is not loaded", path)
+	}
+
+	return newCSVFile(path, partmasterHeaders), nil
+}
+
+// categoryRows returns the rows of parts in a category
+func (s *KiCadServer) categoryRows(category string) []cachedRow {
+	if rows, ok := s.cacheQuery(func(c *partCache) ([]partRef, error) {
+		return c.byCategory(category)
+	}); ok {
+		return rows
+	}
+
+	var rows []cachedRow
+	for _, file := range s.collection().Files {
+		for i, row := range file.Rows {
+			if s.extractCategory(s.cell(file, row, "IPN")) == category {
+				rows = append(rows, cachedRow{file, i})
 			}
+		}
+	}
+	return rows
+}
 
-			if rowPartID == partID {
-				fields := make(map[string]KiCadPartField)
-				partName := ""
-				category := s.extractCategory(partID)
+// deletePart removes all rows for a part. Parts used by a source BOM or
+// release script in the workspace are not deleted.
+func (s *KiCadServer) deletePart(user *serverUser, partID string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
 
-				// Add all fields from the CSV dynamically
-				for i, header := range file.Headers {
-					if i < len(row) && row[i] != "" && header != "" {
-						fields[header] = KiCadPartField{Value: row[i]}
+	rows := editRows(s.findPartRows(partID))
+	if len(rows) == 0 {
+		return &apiError{http.StatusNotFound, "part not found"}
+	}
 
-						// Set name from Description field
-						if header == "Description" {
-							partName = row[i]
-						}
-					}
-				}
+	refs, err := findBomReferences(ipn(partID), s.config.TemplatesDir)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		return &apiError{http.StatusConflict, fmt.Sprintf("part %v is used in:\n  %v",
+			partID, strings.Join(refs, "\n  "))}
+	}
 
-				return &KiCadPartDetail{
-					ID:             partID,
-					Name:           partName,
-					SymbolIDStr:    s.getSymbolIDFromCategory(category),
-					ExcludeFromBOM: "false", // Default to include in BOM
-					Fields:         fields,
-					Revision:       s.extractRevision(partID),
-				}
+	// delete rows from the end so indexes stay valid
+	before := rowValues(rows)
+	files := rowFiles(rows)
+	for _, file := range files {
+		var idxs []int
+		for _, r := range rows {
+			if r.file == file {
+				idxs = append(idxs, r.row)
 			}
 		}
+		sort.Sort(sort.Reverse(sort.IntSlice(idxs)))
+		for _, i := range idxs {
+			file.Rows = append(file.Rows[:i], file.Rows[i+1:]...)
+		}
 	}
 
+	if err := s.saveCSVFiles(files); err != nil {
+		return err
+	}
+
+	s.recordChange(partChange{user: user, action: "delete", part: partID,
+		before: before, files: files})
 	return nil
 }
 
-// updatePart updates editable fields for a part and saves to disk
-func (s *KiCadServer) updatePart(partID string, req PartUpdateRequest) error {
-	file, rowIdx := s.findPart(partID)
-	if file == nil {
-		return fmt.Errorf("part not found")
+// obsoletePart sets the lifecycle column of all rows for a part to obsolete
+func (s *KiCadServer) obsoletePart(user *serverUser, partID string) (*KiCadPartDetail, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	rows := editRows(s.findPartRows(partID))
+	if len(rows) == 0 {
+		return nil, &apiError{http.StatusNotFound, "part not found"}
 	}
-	row := file.Rows[rowIdx]
-	if len(row) < len(file.Headers) {
-		padded := make([]string, len(file.Headers))
-		copy(padded, row)
-		row = padded
-		file.Rows[rowIdx] = row
+
+	before := rowValues(rows)
+	for _, r := range rows {
+		s.setCell(r.file, r.row, lifecycleColumn, lifecycleObsolete)
 	}
 
-	if req.Description != "" {
-		descIdx := s.ensureColumn(file, "Description")
-		file.Rows[rowIdx][descIdx] = req.Description
+	files := rowFiles(rows)
+	if err := s.saveCSVFiles(files); err != nil {
+		return nil, err
 	}
 
-	for i, src := range req.Sources {
-		mfrHeader := "Manufacturer"
-		mpnHeader := "MPN"
-		if i > 0 {
-			idx := i + 1
-			mfrHeader = fmt.Sprintf("Manufacturer%d", idx)
-			mpnHeader = fmt.Sprintf("MPN%d", idx)
+	s.recordChange(partChange{user: user, action: "obsolete", part: partID,
+		before: before, after: rowValues(s.findPartRows(partID)), files: files})
+	return s.getPartDetail(partID), nil
+}
+
+// saveCSVFile saves an edited file. If the file was changed on disk since it
+// was loaded, the edit is discarded and the files are reloaded so the client
+// can retry with the current data.
+func (s *KiCadServer) saveCSVFile(file *CSVFile) error {
+	err := saveCSVFile(file)
+	if isConflict(err) {
+		if lerr := s.readCSVCollection(); lerr != nil {
+			log.Printf("Error reloading CSV files: %v", lerr)
 		}
-		mfrIdx := s.ensureColumn(file, mfrHeader)
-		mpnIdx := s.ensureColumn(file, mpnHeader)
-		file.Rows[rowIdx][mfrIdx] = src.Manufacturer
-		file.Rows[rowIdx][mpnIdx] = src.MPN
 	}
+	return err
+}
 
-	if err := saveCSVFile(file); err != nil {
-		return err
+// saveCSVFiles saves edited copies of files and reloads the collection. If a
+// file can not be saved, the files are reloaded so files that were saved
+// before it are served.
+func (s *KiCadServer) saveCSVFiles(files []*CSVFile) error {
+	for _, file := range files {
+		if err := s.saveCSVFile(file); err != nil {
+			if !isConflict(err) {
+				if lerr := s.readCSVCollection(); lerr != nil {
+					log.Printf("Error reloading CSV files: %v", lerr)
+				}
+			}
+			return err
+		}
 	}
-	return s.loadCSVCollection()
+	return s.readCSVCollection()
 }
 
-// startNewRevision creates a new part revision and returns its details
-func (s *KiCadServer) startNewRevision(partID string) (*KiCadPartDetail, error) {
-	file, rowIdx := s.findPart(partID)
-	if file == nil {
-		return nil, fmt.Errorf("part not found")
+// rowFiles returns the files the rows are in
+func rowFiles(rows []cachedRow) []*CSVFile {
+	var files []*CSVFile
+	for _, r := range rows {
+		if !lo.Contains(files, r.file) {
+			files = append(files, r.file)
+		}
 	}
+	return files
+}
 
-	ipnIdx := s.findColumnIndex(file, "IPN")
-	if ipnIdx < 0 {
-		return nil, fmt.Errorf("IPN column missing")
+// startNewRevision creates the next revision of a part with the same sources,
+// and returns its details
+func (s *KiCadServer) startNewRevision(user *serverUser, partID string) (*KiCadPartDetail, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	rows := editRows(s.partRows(partID))
+	if len(rows) == 0 {
+		return nil, &apiError{http.StatusNotFound, "part not found"}
 	}
 
-	row := file.Rows[rowIdx]
-	parts := strings.Split(row[ipnIdx], "-")
+	parts := strings.Split(partID, "-")
 	if len(parts) != 3 {
 		return nil, fmt.Errorf("invalid IPN format")
 	}
@@ -469,22 +1081,38 @@ func (s *KiCadServer) startNewRevision(partID string) (*KiCadPartDetail, error)
 	parts[2] = fmt.Sprintf("%04d", rev)
 	newIPN := strings.Join(parts, "-")
 
-	newRow := make([]string, len(file.Headers))
-	copy(newRow, row)
-	newRow[ipnIdx] = newIPN
-	file.Rows = append(file.Rows, newRow)
+	if len(s.findPartRows(newIPN)) > 0 {
+		return nil, &apiError{http.StatusConflict, fmt.Sprintf("part %v already exists", newIPN)}
+	}
 
-	if err := saveCSVFile(file); err != nil {
-		return nil, err
+	// copy every source, in priority order
+	for _, r := range rows {
+		ipnIdx := s.findColumnIndex(r.file, "IPN")
+		if ipnIdx < 0 {
+			return nil, fmt.Errorf("IPN column missing")
+		}
+		newRow := make([]string, len(r.file.Headers))
+		copy(newRow, r.file.Rows[r.row])
+		newRow[ipnIdx] = newIPN
+		r.file.Rows = append(r.file.Rows, newRow)
 	}
-	if err := s.loadCSVCollection(); err != nil {
+
+	files := rowFiles(rows)
+	if err := s.saveCSVFiles(files); err != nil {
 		return nil, err
 	}
+
+	s.recordChange(partChange{user: user, action: "revision", part: newIPN, from: partID,
+		after: rowValues(s.partRows(newIPN)), files: files})
 	return s.getPartDetail(newIPN), nil
 }
 
 // getSymbolIDFromCategory generates a symbol ID based on category
 func (s *KiCadServer) getSymbolIDFromCategory(category string) string {
+	if c := s.config.category(category); c != nil && c.Symbol != "" {
+		return c.Symbol
+	}
+
 	// Map categories to common KiCad symbol library symbols
 	symbolMap := map[string]string{
 		"CAP": "Device:C",
@@ -520,8 +1148,7 @@ func (s *KiCadServer) getSymbolIDFromCategory(category string) string {
 
 // rootHandler handles the root API endpoint
 func (s *KiCadServer) rootHandler(w http.ResponseWriter, r *http.Request) {
-	if !s.authenticate(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if s.authorize(w, r) == nil {
 		return
 	}
 
@@ -539,8 +1166,7 @@ func (s *KiCadServer) rootHandler(w http.ResponseWriter, r *http.Request) {
 
 // categoriesHandler handles the categories endpoint
 func (s *KiCadServer) categoriesHandler(w http.ResponseWriter, r *http.Request) {
-	if !s.authenticate(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if s.authorize(w, r) == nil {
 		return
 	}
 
@@ -552,8 +1178,7 @@ func (s *KiCadServer) categoriesHandler(w http.ResponseWriter, r *http.Request)
 
 // partsByCategoryHandler handles the parts by category endpoint
 func (s *KiCadServer) partsByCategoryHandler(w http.ResponseWriter, r *http.Request) {
-	if !s.authenticate(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if s.authorize(w, r) == nil {
 		return
 	}
 
@@ -567,19 +1192,51 @@ func (s *KiCadServer) partsByCategoryHandler(w http.ResponseWriter, r *http.Requ
 	_ = json.NewEncoder(w).Encode(parts)
 }
 
-// partsRouter routes part detail and revision endpoints
+// partsHandler handles creating parts
+func (s *KiCadServer) partsHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.authorize(w, r)
+	if user == nil {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PartCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	part, err := s.createPart(user, req)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(part)
+}
+
+// partsRouter routes part detail, revision, and obsolete endpoints
 func (s *KiCadServer) partsRouter(w http.ResponseWriter, r *http.Request) {
 	if strings.HasSuffix(r.URL.Path, "/revision") {
 		s.partRevisionHandler(w, r)
 		return
 	}
+	if strings.HasSuffix(r.URL.Path, "/obsolete") {
+		s.partObsoleteHandler(w, r)
+		return
+	}
 	s.partDetailHandler(w, r)
 }
 
-// partDetailHandler handles GET/PUT for part details
+// partDetailHandler handles GET/PUT/DELETE for part details
 func (s *KiCadServer) partDetailHandler(w http.ResponseWriter, r *http.Request) {
-	if !s.authenticate(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	user := s.authorize(w, r)
+	if user == nil {
 		return
 	}
 
@@ -602,8 +1259,8 @@ func (s *KiCadServer) partDetailHandler(w http.ResponseWriter, r *http.Request)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
-		if err := s.updatePart(partID, req); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := s.updatePart(user, partID, req); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
 			return
 		}
 		part := s.getPartDetail(partID)
@@ -613,15 +1270,42 @@ func (s *KiCadServer) partDetailHandler(w http.ResponseWriter, r *http.Request)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(part)
+	case http.MethodDelete:
+		if err := s.deletePart(user, partID); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// partObsoleteHandler marks a part obsolete
+func (s *KiCadServer) partObsoleteHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.authorize(w, r)
+	if user == nil {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/parts/")
+	partID := strings.TrimSuffix(path, "/obsolete")
+	part, err := s.obsoletePart(user, partID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(part)
+}
+
 // partRevisionHandler handles creating a new revision for a part
 func (s *KiCadServer) partRevisionHandler(w http.ResponseWriter, r *http.Request) {
-	if !s.authenticate(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	user := s.authorize(w, r)
+	if user == nil {
 		return
 	}
 	if r.Method != http.MethodPost {
@@ -630,15 +1314,37 @@ func (s *KiCadServer) partRevisionHandler(w http.ResponseWriter, r *http.Request
 	}
 	path := strings.TrimPrefix(r.URL.Path, "/v1/parts/")
 	partID := strings.TrimSuffix(path, "/revision")
-	part, err := s.startNewRevision(partID)
+	part, err := s.startNewRevision(user, partID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(part)
 }
 
+// apiError is an error with the HTTP status code returned to the client
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// errorStatus returns the HTTP status code for an error from an edit
+func errorStatus(err error) int {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e.status
+	}
+	if isConflict(err) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 // getScheme determines the URL scheme (http or https)
 func getScheme(r *http.Request) string {
 	if r.TLS != nil {
@@ -650,40 +1356,61 @@ func getScheme(r *http.Request) string {
 	return "http"
 }
 
-// StartKiCadServer starts the KiCad HTTP API server
-func StartKiCadServer(pmDir, token string, port int) error {
-	server, err := NewKiCadServer(pmDir, token)
+// StartKiCadServer starts the KiCad HTTP API server and serves until SIGINT
+// or SIGTERM
+func StartKiCadServer(config *Config) error {
+	server, err := NewKiCadServer(config.PMDir, config.Server.Token)
 	if err != nil {
 		return fmt.Errorf("failed to create KiCad server: %w", err)
 	}
+	server.config = config
 
-	// Serve frontend files if they exist
-	if execPath, err := os.Executable(); err == nil {
-		staticDir := filepath.Join(filepath.Dir(execPath), "frontend", "dist")
-		if _, err := os.Stat(staticDir); err == nil {
-			http.Handle("/", http.FileServer(http.Dir(staticDir)))
+	if config.Server.UsersFile != "" {
+		users, err := loadUsers(config.Server.UsersFile)
+		if err != nil {
+			return err
 		}
+		server.users = append(server.users, users...)
 	}
 
-	// Set up routes
-	http.HandleFunc("/v1/", server.rootHandler)
-	http.HandleFunc("/v1/categories.json", server.categoriesHandler)
-	http.HandleFunc("/v1/parts/category/", server.partsByCategoryHandler)
-	http.HandleFunc("/v1/parts/", server.partsRouter)
+	if err := server.enableCache(); err != nil {
+		return fmt.Errorf("failed to open cache: %w", err)
+	}
 
-	// Add a health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	tlsConfig, err := config.Server.tlsConfig()
+	if err != nil {
+		return err
+	}
 
-	addr := fmt.Sprintf(":%d", port)
-	log.Printf("Starting KiCad HTTP Library API server on %s", addr)
+	addr := net.JoinHostPort(config.Server.Address, strconv.Itoa(config.Server.Port))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	host := config.Server.Address
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	base := fmt.Sprintf("%v://%v", scheme, net.JoinHostPort(host, strconv.Itoa(config.Server.Port)))
+
+	log.Printf("Starting KiCad HTTP Library API server on %s", ln.Addr())
 	log.Printf("API endpoints:")
-	log.Printf("  Root: http://localhost%s/v1/", addr)
-	log.Printf("  Categories: http://localhost%s/v1/categories.json", addr)
-	log.Printf("  Parts by category: http://localhost%s/v1/parts/category/{category_id}.json", addr)
-	log.Printf("  Part detail: http://localhost%s/v1/parts/{part_id}.json", addr)
+	log.Printf("  Root: %s/v1/", base)
+	log.Printf("  Categories: %s/v1/categories.json", base)
+	log.Printf("  Parts by category: %s/v1/parts/category/{category_id}.json", base)
+	log.Printf("  Part detail: %s/v1/parts/{part_id}.json", base)
+	log.Printf("  Create part: POST %s/v1/parts.json", base)
+	log.Printf("  Search: %s/v1/search?q={query}", base)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	return http.ListenAndServe(addr, nil)
+	return server.serve(ctx, ln)
 }
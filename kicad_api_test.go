@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer creates a server for a partmaster directory with the given
+// files
+func newTestServer(t *testing.T, files map[string]string) *KiCadServer {
+	t.Helper()
+	pmDir := t.TempDir()
+	for n, c := range files {
+		if err := os.WriteFile(filepath.Join(pmDir, n), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := NewKiCadServer(pmDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func postJSON(t *testing.T, h http.HandlerFunc, url string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, url, bytes.NewReader(data)))
+	return w
+}
+
+func TestCreatePart(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv":      "IPN,Description,MPN\nCAP-001-0001,10uF,abc\nCAP-007-0002,1uF,def\n",
+		"passives.csv": "IPN,Description\nIND-001-0001,1uH\n",
+	})
+
+	// explicit IPN in an existing file, fields matched by alias
+	w := postJSON(t, s.partsHandler, "/v1/parts.json", PartCreateRequest{
+		ID:       "CAP-001-0002",
+		Name:     "22uF",
+		Category: "CAP",
+		Fields:   map[string]string{"mfr. part #": "ghi", "Voltage": "16V"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %v: %v", w.Code, w.Body.String())
+	}
+
+	var part KiCadPartDetail
+	if err := json.Unmarshal(w.Body.Bytes(), &part); err != nil {
+		t.Fatal(err)
+	}
+	if part.ID != "CAP-001-0002" || part.Name != "22uF" || part.Fields["MPN"].Value != "ghi" ||
+		part.Fields["Voltage"].Value != "16V" {
+		t.Errorf("wrong part: %+v", part)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.pmDir, "cap.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "IPN,Description,MPN,Voltage\nCAP-001-0001,10uF,abc,\nCAP-007-0002,1uF,def,\nCAP-001-0002,22uF,ghi,16V\n"
+	if string(data) != exp {
+		t.Errorf("expected:\n%q\ngot:\n%q", exp, data)
+	}
+
+	// allocate an IPN in a new category file
+	w = postJSON(t, s.partsHandler, "/v1/parts.json", PartCreateRequest{
+		Name:     "10k",
+		Category: "res",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %v: %v", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &part); err != nil {
+		t.Fatal(err)
+	}
+	if part.ID != "RES-001-0000" {
+		t.Errorf("wrong IPN allocated: %v", part.ID)
+	}
+	if !fileExists(filepath.Join(s.pmDir, "res.csv")) {
+		t.Errorf("res.csv not created")
+	}
+
+	// add to the file that has parts in the category
+	w = postJSON(t, s.partsHandler, "/v1/parts.json", PartCreateRequest{
+		Name:     "10uH",
+		Category: "IND",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %v: %v", w.Code, w.Body.String())
+	}
+	data, err = os.ReadFile(filepath.Join(s.pmDir, "passives.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "IPN,Description\nIND-001-0001,1uH\nIND-002-0000,10uH\n"; string(data) != exp {
+		t.Errorf("expected:\n%q\ngot:\n%q", exp, data)
+	}
+	if fileExists(filepath.Join(s.pmDir, "ind.csv")) {
+		t.Errorf("ind.csv created for a category in passives.csv")
+	}
+
+	// allocate after the highest number in the category
+	id, err := s.allocateIPN("CAP")
+	if err != nil || id != "CAP-008-0000" {
+		t.Errorf("expected CAP-008-0000, got %v, %v", id, err)
+	}
+
+	errorTests := []struct {
+		req  PartCreateRequest
+		code int
+	}{
+		{PartCreateRequest{ID: "CAP-001-0001"}, http.StatusConflict},
+		{PartCreateRequest{ID: "CAP-1"}, http.StatusBadRequest},
+		{PartCreateRequest{ID: "RES-001-0001", Category: "CAP"}, http.StatusBadRequest},
+		{PartCreateRequest{Category: "C"}, http.StatusBadRequest},
+	}
+
+	for _, test := range errorTests {
+		w := postJSON(t, s.partsHandler, "/v1/parts.json", test.req)
+		if w.Code != test.code {
+			t.Errorf("%+v: expected %v, got %v: %v", test.req, test.code, w.Code,
+				strings.TrimSpace(w.Body.String()))
+		}
+	}
+}
+
+func TestDeletePart(t *testing.T) {
+	root := t.TempDir()
+	configureWorkspace(root, nil)
+	defer configureWorkspace(".", nil)
+
+	files := map[string]string{
+		"PCB-019.csv": "Ref,Qty,Value,Cmnt,Desc,Package,IPN\nC1,1,10uF,,,0805,CAP-001-0001\n",
+		// release scripts that can not be loaded do not stop deletes, but are
+		// references if they mention the IPN
+		"PCA-020.yml": "add:\n  - ipn: CAP-001-0003\n    unknown: field\n",
+		"PCA-021.yml": "remove: [\n",
+	}
+	for n, c := range files {
+		if err := os.WriteFile(filepath.Join(root, n), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description,MPN,Priority\nCAP-001-0001,10uF,abc,1\nCAP-001-0002,1uF,def,1\nCAP-001-0002,1uF,ghi,2\n" +
+			"CAP-001-0003,2.2uF,jkl,1\n",
+	})
+
+	del := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.partsRouter(w, httptest.NewRequest(http.MethodDelete, "/v1/parts/"+id+".json", nil))
+		return w
+	}
+
+	if w := del("CAP-001-0001"); w.Code != http.StatusConflict ||
+		!strings.Contains(w.Body.String(), "PCB-019.csv") {
+		t.Errorf("expected 409 listing PCB-019.csv, got %v: %v", w.Code, w.Body.String())
+	}
+
+	if w := del("CAP-001-0003"); w.Code != http.StatusConflict ||
+		!strings.Contains(w.Body.String(), "PCA-020.yml") {
+		t.Errorf("expected 409 listing PCA-020.yml, got %v: %v", w.Code, w.Body.String())
+	}
+
+	if w := del("CAP-001-0002"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %v: %v", w.Code, w.Body.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.pmDir, "cap.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "IPN,Description,MPN,Priority\nCAP-001-0001,10uF,abc,1\nCAP-001-0003,2.2uF,jkl,1\n"
+	if string(data) != exp {
+		t.Errorf("wrong file after delete:\nexp:\n%v\ngot:\n%v", exp, string(data))
+	}
+
+	if w := del("CAP-001-0002"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %v", w.Code)
+	}
+}
+
+func TestObsoletePart(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description,MPN\nCAP-001-0001,10uF,abc\nCAP-001-0002,1uF,def\n",
+	})
+
+	w := postJSON(t, s.partsRouter, "/v1/parts/CAP-001-0001/obsolete", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %v", w.Code, w.Body.String())
+	}
+
+	parts := s.getPartsByCategory("CAP")
+	if len(parts) != 1 || parts[0].ID != "CAP-001-0002" {
+		t.Errorf("obsolete part should not be listed: %+v", parts)
+	}
+
+	part := s.getPartDetail("CAP-001-0001")
+	if part == nil || part.Fields[lifecycleColumn].Value != lifecycleObsolete {
+		t.Errorf("obsolete part should still resolve: %+v", part)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.pmDir, "cap.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "IPN,Description,MPN,Lifecycle\nCAP-001-0001,10uF,abc,Obsolete\nCAP-001-0002,1uF,def,\n"
+	if string(data) != exp {
+		t.Errorf("wrong file:\nexp:\n%v\ngot:\n%v", exp, string(data))
+	}
+}
+
+func TestUpdatePartSources(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description,Manufacturer,MPN,Datasheet,Priority\n" +
+			"CAP-001-0001,10uF,AVX,abc,avx.pdf,1\n" +
+			"CAP-001-0001,10uF,Kemet,def,kemet.pdf,2\n" +
+			"CAP-001-0002,1uF,AVX,ghi,,\n",
+	})
+
+	put := func(id string, req PartUpdateRequest) *httptest.ResponseRecorder {
+		data, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.partsRouter(w, httptest.NewRequest(http.MethodPut, "/v1/parts/"+id+".json", bytes.NewReader(data)))
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		sources []PartSource
+		exp     string
+	}{
+		{"reorder", []PartSource{{"Kemet", "def", 0}, {"AVX", "abc", 0}},
+			"CAP-001-0001,10uF,AVX,abc,avx.pdf,2\n" +
+				"CAP-001-0001,10uF,Kemet,def,kemet.pdf,1\n"},
+		{"add", []PartSource{{"Kemet", "def", 0}, {"AVX", "abc", 0}, {"Murata", "jkl", 0}},
+			"CAP-001-0001,10uF,AVX,abc,avx.pdf,2\n" +
+				"CAP-001-0001,10uF,Kemet,def,kemet.pdf,1\n" +
+				"CAP-001-0001,10uF,Murata,jkl,,3\n"},
+		{"delete", []PartSource{{"Murata", "jkl", 0}, {"AVX", "abc", 0}},
+			"CAP-001-0001,10uF,AVX,abc,avx.pdf,2\n" +
+				"CAP-001-0001,10uF,Murata,jkl,,1\n"},
+	}
+
+	for _, test := range tests {
+		w := put("CAP-001-0001", PartUpdateRequest{Sources: test.sources})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: expected 200, got %v: %v", test.name, w.Code, w.Body.String())
+		}
+
+		var part KiCadPartDetail
+		if err := json.Unmarshal(w.Body.Bytes(), &part); err != nil {
+			t.Fatal(err)
+		}
+		for i, src := range test.sources {
+			src.Priority = i + 1
+			if i >= len(part.Sources) || part.Sources[i] != src {
+				t.Errorf("%v: wrong sources: %+v", test.name, part.Sources)
+				break
+			}
+		}
+		if part.Fields["MPN"].Value != test.sources[0].MPN {
+			t.Errorf("%v: fields should come from the preferred source: %+v", test.name, part.Fields)
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.pmDir, "cap.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := "IPN,Description,Manufacturer,MPN,Datasheet,Priority\n" + test.exp +
+			"CAP-001-0002,1uF,AVX,ghi,,\n"
+		if string(data) != exp {
+			t.Errorf("%v: wrong file:\nexp:\n%v\ngot:\n%v", test.name, exp, string(data))
+		}
+	}
+
+	// a single source without a priority keeps its blank priority
+	if w := put("CAP-001-0002", PartUpdateRequest{Description: "2.2uF",
+		Sources: []PartSource{{"TDK", "mno", 0}}}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %v", w.Code, w.Body.String())
+	}
+	if part := s.getPartDetail("CAP-001-0002"); part.Name != "2.2uF" || len(part.Sources) != 1 ||
+		part.Sources[0] != (PartSource{"TDK", "mno", 0}) {
+		t.Errorf("wrong part: %+v", part)
+	}
+
+	if w := put("CAP-001-0001", PartUpdateRequest{Sources: []PartSource{}}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 when deleting all sources, got %v", w.Code)
+	}
+}
+
+func TestUpdatePartSourcesNoPriority(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description,Manufacturer,MPN\nCAP-001-0001,10uF,AVX,abc\n",
+	})
+
+	err := s.updatePart(anonymousUser, "CAP-001-0001", PartUpdateRequest{
+		Sources: []PartSource{{"Kemet", "def", 0}, {"AVX", "abc", 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.pmDir, "cap.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "IPN,Description,Manufacturer,MPN,Priority\n" +
+		"CAP-001-0001,10uF,AVX,abc,2\n" +
+		"CAP-001-0001,10uF,Kemet,def,1\n"
+	if string(data) != exp {
+		t.Errorf("wrong file:\nexp:\n%v\ngot:\n%v", exp, string(data))
+	}
+}
+
+func TestStartNewRevision(t *testing.T) {
+	configureWorkspace(t.TempDir(), nil)
+	defer configureWorkspace(".", nil)
+
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description,Manufacturer,MPN,Priority\n" +
+			"CAP-001-0001,10uF,Kemet,def,2\n" +
+			"CAP-001-0001,10uF,AVX,abc,1\n",
+	})
+
+	part, err := s.startNewRevision(anonymousUser, "CAP-001-0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []PartSource{{"AVX", "abc", 1}, {"Kemet", "def", 2}}
+	if part.ID != "CAP-001-0002" || len(part.Sources) != 2 ||
+		part.Sources[0] != exp[0] || part.Sources[1] != exp[1] {
+		t.Errorf("wrong revision: %+v", part)
+	}
+
+	// the next revision of the old part already exists
+	_, err = s.startNewRevision(anonymousUser, "CAP-001-0001")
+	if errorStatus(err) != http.StatusConflict {
+		t.Errorf("expected conflict, got %v", err)
+	}
+	if rows := s.findPartRows("CAP-001-0002"); len(rows) != 2 {
+		t.Errorf("expected 2 rows for CAP-001-0002, got %v", len(rows))
+	}
+}
+
+// TestConcurrentAccess edits parts while they are read and while the files
+// are reloaded. Run with -race to check for data races.
+func TestConcurrentAccess(t *testing.T) {
+	for _, cache := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cache=%v", cache), func(t *testing.T) {
+			s := newTestServer(t, map[string]string{
+				"cap.csv": "IPN,Description,Manufacturer,MPN,Priority\n" +
+					"CAP-001-0001,10uF,AVX,abc,1\nCAP-001-0001,10uF,Kemet,def,2\n",
+			})
+			if cache {
+				s.config.Cache = CacheConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "cache.db")}
+				if err := s.enableCache(); err != nil {
+					t.Fatal(err)
+				}
+				defer s.cache.close()
+			}
+
+			stop := make(chan struct{})
+			watching := make(chan struct{})
+			go func() {
+				s.watchCSVFiles(time.Millisecond, stop)
+				close(watching)
+			}()
+
+			const writers = 4
+			const edits = 5
+
+			var wg sync.WaitGroup
+			done := make(chan struct{})
+
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < edits; j++ {
+						id := fmt.Sprintf("CAP-%03d-%04d", 100+i, j)
+						if _, err := s.createPart(anonymousUser, PartCreateRequest{ID: id, Name: "new"}); err != nil {
+							t.Errorf("create %v: %v", id, err)
+						}
+						err := s.updatePart(anonymousUser, "CAP-001-0001", PartUpdateRequest{
+							Description: fmt.Sprintf("edit %v %v", i, j),
+							Sources:     []PartSource{{"Kemet", "def", 0}, {"AVX", "abc", 0}},
+						})
+						if err != nil {
+							t.Errorf("update: %v", err)
+						}
+					}
+				}(i)
+			}
+
+			var readers sync.WaitGroup
+			get := func(url string, h http.HandlerFunc) {
+				defer readers.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					w := httptest.NewRecorder()
+					h(w, httptest.NewRequest(http.MethodGet, url, nil))
+					if w.Code != http.StatusOK {
+						t.Errorf("%v: expected 200, got %v: %v", url, w.Code, w.Body.String())
+						return
+					}
+				}
+			}
+			readers.Add(4)
+			go get("/v1/parts/CAP-001-0001.json", s.partsRouter)
+			go get("/v1/parts/category/CAP.json", s.partsByCategoryHandler)
+			go get("/v1/search?q=10uF", s.searchHandler)
+			go get("/v1/categories.json", s.categoriesHandler)
+
+			wg.Wait()
+			close(done)
+			readers.Wait()
+			close(stop)
+			<-watching
+
+			for i := 0; i < writers; i++ {
+				for j := 0; j < edits; j++ {
+					id := fmt.Sprintf("CAP-%03d-%04d", 100+i, j)
+					if s.getPartDetail(id) == nil {
+						t.Errorf("%v was not created", id)
+					}
+				}
+			}
+
+			part := s.getPartDetail("CAP-001-0001")
+			if part == nil || !strings.HasPrefix(part.Name, "edit ") || len(part.Sources) != 2 ||
+				part.Sources[0].MPN != "def" {
+				t.Errorf("wrong part after edits: %+v", part)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultAuditLog is the name of the audit log in pmDir if server.auditLog is
+// not set
+const defaultAuditLog = "gitplm-audit.jsonl"
+
+// partChange is a change made to a part through the HTTP server
+type partChange struct {
+	user *serverUser
+	// action is create, update, delete, obsolete, or revision
+	action string
+	part   string
+	// from is the part a revision was created from
+	from string
+	// before and after are the rows of the part
+	before []csvExtra
+	after  []csvExtra
+	files  []*CSVFile
+}
+
+// auditEntry is a line in the audit log
+type auditEntry struct {
+	Time   time.Time  `json:"time"`
+	User   string     `json:"user"`
+	Action string     `json:"action"`
+	Part   string     `json:"part"`
+	From   string     `json:"from,omitempty"`
+	Before []csvExtra `json:"before,omitempty"`
+	After  []csvExtra `json:"after,omitempty"`
+}
+
+// rowValues returns the values of rows by column
+func rowValues(rows []cachedRow) []csvExtra {
+	var ret []csvExtra
+	for _, r := range rows {
+		row := r.file.Rows[r.row]
+		var values csvExtra
+		for i, header := range r.file.Headers {
+			value := ""
+			if i < len(row) {
+				value = row[i]
+			}
+			values = append(values, csvField{header, value})
+		}
+		ret = append(ret, values)
+	}
+	return ret
+}
+
+// auditLogPath returns the path of the audit log
+func (s *KiCadServer) auditLogPath() string {
+	if s.config.Server.AuditLog != "" {
+		return s.config.Server.AuditLog
+	}
+	return filepath.Join(s.pmDir, defaultAuditLog)
+}
+
+// recordChange appends a change to the audit log, and commits the changed
+// files to git if server.gitCommit is set. The change is already saved, so
+// errors are logged and not returned.
+func (s *KiCadServer) recordChange(c partChange) {
+	log.Printf("Part %v: %v by %v", c.part, c.action, c.user)
+
+	entry := auditEntry{
+		Time:   time.Now().UTC(),
+		User:   c.user.Name,
+		Action: c.action,
+		Part:   c.part,
+		From:   c.from,
+		Before: c.before,
+		After:  c.after,
+	}
+	if err := appendAuditLog(s.auditLogPath(), entry); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+
+	if !s.config.Server.GitCommit {
+		return
+	}
+
+	var paths []string
+	for _, f := range c.files {
+		paths = append(paths, f.Path)
+	}
+	if p := s.auditLogPath(); inDir(p, s.pmDir) {
+		paths = append(paths, p)
+	}
+
+	if err := gitCommit(s.pmDir, paths, c.user, c.message()); err != nil {
+		log.Printf("Error committing %v: %v", c.part, err)
+	}
+}
+
+// appendAuditLog appends an entry to the audit log as a line of JSON
+func appendAuditLog(path string, entry auditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// message returns the git commit message for a change. The body lists the
+// values that changed.
+func (c partChange) message() string {
+	var subject string
+	switch c.action {
+	case "create":
+		subject = fmt.Sprintf("Create %v", c.part)
+	case "delete":
+		subject = fmt.Sprintf("Delete %v", c.part)
+	case "obsolete":
+		subject = fmt.Sprintf("Mark %v obsolete", c.part)
+	case "revision":
+		subject = fmt.Sprintf("Create %v from %v", c.part, c.from)
+	default:
+		subject = fmt.Sprintf("Update %v", c.part)
+	}
+
+	lines := []string{subject, ""}
+	for i := 0; i < max(len(c.before), len(c.after)); i++ {
+		switch {
+		case i >= len(c.before):
+			lines = append(lines, "Added: "+rowSummary(c.after[i]))
+		case i >= len(c.after):
+			lines = append(lines, "Removed: "+rowSummary(c.before[i]))
+		default:
+			for _, f := range c.after[i] {
+				old, _ := c.before[i].get(f.Name)
+				if old != f.Value {
+					lines = append(lines, fmt.Sprintf("%v: %q -> %q", f.Name, old, f.Value))
+				}
+			}
+		}
+	}
+	lines = append(lines, "", fmt.Sprintf("Changed by %v through the GitPLM HTTP server.", c.user))
+
+	return strings.Join(lines, "\n")
+}
+
+// rowSummary joins the non-empty values of a row
+func rowSummary(values csvExtra) string {
+	var s []string
+	for _, f := range values {
+		if f.Value != "" {
+			s = append(s, f.Value)
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// inDir returns true if path is in dir or one of its subdirectories
+func inDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// gitCommit commits files to the git repository dir is in, authored by a user
+// of the server. Other changes in the repository are not committed.
+func gitCommit(dir string, files []string, user *serverUser, msg string) error {
+	var paths []string
+	for _, f := range files {
+		p, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+	}
+
+	if err := runGit(dir, append([]string{"add", "--"}, paths...)...); err != nil {
+		return err
+	}
+
+	author := fmt.Sprintf("%v <%v>", user.Name, user.Email)
+	return runGit(dir, append([]string{"commit", "--author", author, "-m", msg, "--"}, paths...)...)
+}
+
+// runGit runs a git command in dir
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %v: %v: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
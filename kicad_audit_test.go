@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readAuditLog returns the entries in an audit log
+func readAuditLog(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid audit log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	configureWorkspace(t.TempDir(), nil)
+	defer configureWorkspace(".", nil)
+
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description,Manufacturer,MPN\nCAP-001-0001,10uF,Murata,GRM1\n",
+	})
+	alice := &serverUser{Name: "alice", Role: "editor"}
+
+	if err := s.updatePart(alice, "CAP-001-0001", PartUpdateRequest{Description: "22uF"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.startNewRevision(alice, "CAP-001-0001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.deletePart(anonymousUser, "CAP-001-0002"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAuditLog(t, filepath.Join(s.pmDir, defaultAuditLog))
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 audit entries, got %v", len(entries))
+	}
+
+	exp := []struct {
+		user, action, part string
+		before, after      string
+	}{
+		{"alice", "update", "CAP-001-0001", "10uF", "22uF"},
+		{"alice", "revision", "CAP-001-0002", "", "22uF"},
+		{"anonymous", "delete", "CAP-001-0002", "22uF", ""},
+	}
+
+	description := func(e map[string]any, key string) string {
+		rows, _ := e[key].([]any)
+		if len(rows) == 0 {
+			return ""
+		}
+		row, _ := rows[0].(map[string]any)
+		v, _ := row["Description"].(string)
+		return v
+	}
+
+	for i, e := range exp {
+		got := entries[i]
+		if got["user"] != e.user || got["action"] != e.action || got["part"] != e.part ||
+			description(got, "before") != e.before || description(got, "after") != e.after {
+			t.Errorf("entry %v: expected %+v, got %v", i, e, got)
+		}
+		if got["time"] == "" {
+			t.Errorf("entry %v: no time", i)
+		}
+	}
+}
+
+func TestAuditGitCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description\nCAP-001-0001,10uF\n",
+	})
+	s.config.Server.GitCommit = true
+
+	t.Setenv("GIT_COMMITTER_NAME", "gitplm")
+	t.Setenv("GIT_COMMITTER_EMAIL", "gitplm@example.com")
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = s.pmDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return string(out)
+	}
+	git("init", "-q")
+	git("add", "cap.csv")
+	git("-c", "user.name=init", "-c", "user.email=init@example.com", "commit", "-q", "-m", "Initial")
+
+	// unrelated changes are not committed
+	if err := os.WriteFile(filepath.Join(s.pmDir, "notes.txt"), []byte("draft"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "notes.txt")
+
+	alice := &serverUser{Name: "Alice", Email: "alice@example.com", Role: "editor"}
+	if err := s.updatePart(alice, "CAP-001-0001", PartUpdateRequest{Description: "22uF"}); err != nil {
+		t.Fatal(err)
+	}
+
+	log := git("log", "-1", "--format=%an <%ae>%n%B", "--name-only")
+	for _, exp := range []string{
+		"Alice <alice@example.com>",
+		"Update CAP-001-0001",
+		`Description: "10uF" -> "22uF"`,
+		"Changed by Alice",
+		"cap.csv",
+		defaultAuditLog,
+	} {
+		if !strings.Contains(log, exp) {
+			t.Errorf("expected %q in commit:\n%v", exp, log)
+		}
+	}
+	if strings.Contains(log, "notes.txt") {
+		t.Errorf("unrelated file committed:\n%v", log)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// role is the access level of a user of the HTTP server. Each role can do
+// everything the roles before it can.
+type role int
+
+const (
+	// roleReader can read parts, and is used for KiCad clients
+	roleReader role = iota
+	// roleEditor can create and edit parts, and is used for the web UI
+	roleEditor
+	// roleAdmin can also delete parts
+	roleAdmin
+)
+
+var roleNames = map[string]role{
+	"reader": roleReader,
+	"editor": roleEditor,
+	"admin":  roleAdmin,
+}
+
+func (r role) String() string {
+	for name, v := range roleNames {
+		if v == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// serverUser is a user of the HTTP server, as listed in the users file
+type serverUser struct {
+	Name string `yaml:"name"`
+	// Email is used with Name to attribute changes
+	Email string `yaml:"email"`
+	// Token is the user's token. TokenHash can be used instead so the file
+	// does not contain the token.
+	Token string `yaml:"token"`
+	// TokenHash is the hex encoded SHA-256 hash of the user's token
+	TokenHash string `yaml:"tokenHash"`
+	Role      string `yaml:"role"`
+
+	role role
+	hash []byte
+}
+
+func (u *serverUser) String() string {
+	return u.Name
+}
+
+// anonymousUser is used for requests when authentication is not configured
+var anonymousUser = &serverUser{Name: "anonymous", role: roleAdmin}
+
+// usersFile is the format of the file set with server.usersFile
+type usersFile struct {
+	Users []*serverUser `yaml:"users"`
+}
+
+// loadUsers reads a users file
+func loadUsers(path string) ([]*serverUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading users file: %v", err)
+	}
+
+	var f usersFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("error parsing users file %v: %v", path, err)
+	}
+
+	names := make(map[string]bool)
+	for _, u := range f.Users {
+		if err := u.init(); err != nil {
+			return nil, fmt.Errorf("users file %v: %v", path, err)
+		}
+		if names[u.Name] {
+			return nil, fmt.Errorf("users file %v: duplicate user %v", path, u.Name)
+		}
+		names[u.Name] = true
+	}
+
+	return f.Users, nil
+}
+
+// init validates a user read from the users file, and sets the role and hash
+func (u *serverUser) init() error {
+	if u.Name == "" {
+		return fmt.Errorf("user without a name")
+	}
+
+	r, ok := roleNames[strings.ToLower(u.Role)]
+	if !ok {
+		return fmt.Errorf("user %v: invalid role %q, expected reader, editor, or admin", u.Name, u.Role)
+	}
+	u.role = r
+
+	switch {
+	case u.Token != "" && u.TokenHash != "":
+		return fmt.Errorf("user %v: set token or tokenHash, not both", u.Name)
+	case u.Token != "":
+		h := sha256.Sum256([]byte(u.Token))
+		u.hash = h[:]
+	case u.TokenHash != "":
+		h, err := hex.DecodeString(u.TokenHash)
+		if err != nil || len(h) != sha256.Size {
+			return fmt.Errorf("user %v: tokenHash is not a hex encoded SHA-256 hash", u.Name)
+		}
+		u.hash = h
+	default:
+		return fmt.Errorf("user %v: no token", u.Name)
+	}
+
+	return nil
+}
+
+// authenticate returns the user for the token in the Authorization header of
+// a request, or nil if the token is not valid. KiCad sends "Token <token>",
+// and "Bearer <token>" is also accepted. If no users or token are configured,
+// all requests are made by anonymousUser.
+func (s *KiCadServer) authenticate(r *http.Request) *serverUser {
+	if len(s.users) == 0 {
+		return anonymousUser
+	}
+
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Token ")
+	if !ok {
+		token, ok = strings.CutPrefix(auth, "Bearer ")
+	}
+	if !ok || token == "" {
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	for _, u := range s.users {
+		if subtle.ConstantTimeCompare(hash[:], u.hash) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+// methodRole returns the role needed for a request method. Reading needs
+// reader, deleting needs admin, and all other changes need editor.
+func methodRole(method string) role {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return roleReader
+	case http.MethodDelete:
+		return roleAdmin
+	default:
+		return roleEditor
+	}
+}
+
+// authorize authenticates a request and checks the user has the role needed
+// for the request method. If not, an error is written and nil is returned.
+func (s *KiCadServer) authorize(w http.ResponseWriter, r *http.Request) *serverUser {
+	user := s.authenticate(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	if need := methodRole(r.Method); user.role < need {
+		http.Error(w, fmt.Sprintf("Forbidden: %v needs the %v role", user.Name, need), http.StatusForbidden)
+		return nil
+	}
+
+	return user
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadUsers(t *testing.T) {
+	hash := sha256.Sum256([]byte("secret"))
+
+	tests := []struct {
+		users string
+		err   string
+	}{
+		{"users:\n  - {name: kicad, token: abc, role: reader}\n" +
+			"  - {name: alice, tokenHash: " + hex.EncodeToString(hash[:]) + ", role: Admin}\n", ""},
+		{"users:\n  - {name: kicad, role: reader}\n", "no token"},
+		{"users:\n  - {name: kicad, token: abc, role: owner}\n", "invalid role"},
+		{"users:\n  - {name: kicad, tokenHash: abc, role: reader}\n", "not a hex encoded SHA-256"},
+		{"users:\n  - {name: a, token: x, role: reader}\n  - {name: a, token: y, role: reader}\n", "duplicate user"},
+		{"users:\n  - {name: a, password: x, role: reader}\n", "not found"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "users.yml")
+		if err := os.WriteFile(path, []byte(test.users), 0644); err != nil {
+			t.Fatal(err)
+		}
+		users, err := loadUsers(path)
+		if test.err == "" {
+			if err != nil || len(users) != 2 || users[1].role != roleAdmin {
+				t.Errorf("%v: expected 2 users, got %+v, %v", test.users, users, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error %q, got %v", test.users, test.err, err)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description\nCAP-001-0001,10uF\n",
+	})
+
+	hash := sha256.Sum256([]byte("editor-token"))
+	s.users = nil
+	for _, u := range []*serverUser{
+		{Name: "kicad", Token: "reader-token", Role: "reader"},
+		{Name: "web", TokenHash: hex.EncodeToString(hash[:]), Role: "editor"},
+		{Name: "alice", Token: "admin-token", Role: "admin"},
+	} {
+		if err := u.init(); err != nil {
+			t.Fatal(err)
+		}
+		s.users = append(s.users, u)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		auth   string
+		code   int
+	}{
+		{http.MethodGet, "/v1/parts/CAP-001-0001.json", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/parts/CAP-001-0001.json", "Token wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/parts/CAP-001-0001.json", "Token reader-token", http.StatusOK},
+		{http.MethodGet, "/v1/parts/CAP-001-0001.json", "Bearer reader-token", http.StatusOK},
+		{http.MethodPut, "/v1/parts/CAP-001-0001.json", "Token reader-token", http.StatusForbidden},
+		{http.MethodPost, "/v1/parts/CAP-001-0001/obsolete", "Token editor-token", http.StatusOK},
+		{http.MethodDelete, "/v1/parts/CAP-001-0001.json", "Token editor-token", http.StatusForbidden},
+		{http.MethodDelete, "/v1/parts/CAP-001-0001.json", "Token admin-token", http.StatusNoContent},
+	}
+
+	configureWorkspace(t.TempDir(), nil)
+	defer configureWorkspace(".", nil)
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.url, nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		s.partsRouter(w, r)
+		if w.Code != test.code {
+			t.Errorf("%v %v %q: expected %v, got %v: %v", test.method, test.url, test.auth,
+				test.code, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+
+	// the server token is an admin user
+	s, err := NewKiCadServer(s.pmDir, "server-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodDelete, "/v1/parts/CAP-001-0001.json", nil)
+	r.Header.Set("Authorization", "Token server-token")
+	if u := s.authenticate(r); u == nil || u.role != roleAdmin {
+		t.Errorf("server token should be an admin user, got %+v", u)
+	}
+}
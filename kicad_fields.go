@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// symbolColumn is the partmaster column that overrides the KiCad symbol of
+	// the category for a part
+	symbolColumn = "Symbol"
+	// excludeFromBOMColumn is the partmaster column that excludes a part from
+	// BOMs generated by KiCad
+	excludeFromBOMColumn = "ExcludeFromBOM"
+)
+
+// defaultFields maps standard partmaster columns to the fields KiCad uses for
+// parts from an HTTP library
+var defaultFields = []FieldConfig{
+	{Column: "Value", Field: "value", Visible: lo.ToPtr(true)},
+	{Column: "Reference", Field: "reference", Visible: lo.ToPtr(true)},
+	{Column: "Footprint", Field: "footprint", Visible: lo.ToPtr(false)},
+	{Column: "Datasheet", Field: "datasheet", Visible: lo.ToPtr(false)},
+	{Column: "Description", Field: "description", Visible: lo.ToPtr(false)},
+	{Column: "Keywords", Field: "keywords", Visible: lo.ToPtr(false)},
+}
+
+// fieldMappings returns the field mappings for a category, least specific
+// first: the default fields, server.fields, and then the fields of the
+// category
+func (s *KiCadServer) fieldMappings(category string) []FieldConfig {
+	mappings := append([]FieldConfig{}, defaultFields...)
+	mappings = append(mappings, s.config.Server.Fields...)
+	if c := s.config.category(category); c != nil {
+		mappings = append(mappings, c.Fields...)
+	}
+	return mappings
+}
+
+// kicadFields returns the KiCad fields for a row of a part. Each column is
+// mapped with the last mapping that matches it, and columns without a mapping
+// are sent with the header as the field name. If several columns map to the
+// same field, the column with the most specific mapping is used. Empty
+// columns, and the Symbol and ExcludeFromBOM columns, are not sent.
+func (s *KiCadServer) kicadFields(category string, file *CSVFile, row []string) map[string]KiCadPartField {
+	mappings := s.fieldMappings(category)
+
+	fields := make(map[string]KiCadPartField)
+	rank := make(map[string]int)
+
+	for i, header := range file.Headers {
+		if header == "" || i >= len(row) || row[i] == "" ||
+			matchColumn(header, symbolColumn) || matchColumn(header, excludeFromBOMColumn) {
+			continue
+		}
+
+		name := header
+		field := KiCadPartField{Value: row[i]}
+
+		r := -1
+		for j, m := range mappings {
+			if matchColumn(header, m.Column) {
+				r = j
+			}
+		}
+		if r >= 0 {
+			m := mappings[r]
+			if m.Omit {
+				continue
+			}
+			if m.Field != "" {
+				name = m.Field
+			}
+			if m.Visible != nil {
+				field.Visible = kicadBool(*m.Visible)
+			}
+		}
+
+		if prev, ok := rank[name]; ok && prev > r {
+			continue
+		}
+		fields[name] = field
+		rank[name] = r
+	}
+
+	return fields
+}
+
+// kicadBool formats a bool the way KiCad expects in HTTP library responses
+func kicadBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+// isTrue returns true for the values used in spreadsheets to mean yes
+func isTrue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true", "yes", "y", "1", "x":
+		return true
+	}
+	return false
+}
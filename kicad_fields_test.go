@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/samber/lo"
+)
+
+func TestKiCadFields(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"res.csv": "IPN,Desc,Value,Footprint,Resistance,MPN,Notes,Symbol,Exclude From BOM\n" +
+			"RES-001-0001,10k 1%,,Resistor_SMD:R_0603_1608Metric,10k,RC0603FR-0710KL,internal,,\n" +
+			"RES-002-0001,jumper,0,Resistor_SMD:R_0603_1608Metric,,RC0603JR-070RL,,Device:R_Small,yes\n",
+	})
+
+	s.config.Server.Fields = []FieldConfig{
+		{Column: "MPN", Visible: lo.ToPtr(false)},
+		{Column: "Notes", Omit: true},
+	}
+	s.config.Categories = []CategoryConfig{{
+		Code:   "RES",
+		Symbol: "Device:R",
+		Fields: []FieldConfig{{Column: "Resistance", Field: "value", Visible: lo.ToPtr(true)}},
+	}}
+
+	part := s.getPartDetail("RES-001-0001")
+	exp := map[string]KiCadPartField{
+		"IPN":         {Value: "RES-001-0001"},
+		"description": {Value: "10k 1%", Visible: "False"},
+		"footprint":   {Value: "Resistor_SMD:R_0603_1608Metric", Visible: "False"},
+		"value":       {Value: "10k", Visible: "True"},
+		"MPN":         {Value: "RC0603FR-0710KL", Visible: "False"},
+	}
+	if !reflect.DeepEqual(part.Fields, exp) {
+		t.Errorf("wrong fields:\nexp: %+v\ngot: %+v", exp, part.Fields)
+	}
+	if part.Name != "10k 1%" || part.SymbolIDStr != "Device:R" || part.ExcludeFromBOM != "False" {
+		t.Errorf("wrong part: %+v", part)
+	}
+
+	// the category mapping is more specific, but the Resistance column is
+	// empty, so the Value column is used
+	part = s.getPartDetail("RES-002-0001")
+	if part.Fields["value"].Value != "0" {
+		t.Errorf("expected value from the Value column, got %+v", part.Fields)
+	}
+	if part.SymbolIDStr != "Device:R_Small" || part.ExcludeFromBOM != "True" {
+		t.Errorf("Symbol and ExcludeFromBOM columns not used: %+v", part)
+	}
+	if _, ok := part.Fields["Symbol"]; ok {
+		t.Errorf("Symbol column should not be a field: %+v", part.Fields)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// searchDefaultLimit is the page size used when none is requested
+	searchDefaultLimit = 50
+	// searchMaxLimit is the largest page size that can be requested
+	searchMaxLimit = 500
+)
+
+// fuzzyWordLen is the length of the shortest query word that is matched with
+// an edit
+const fuzzyWordLen = 4
+
+// searchColumns are the columns matched by the words in a search query
+var searchColumns = []string{"IPN", "MPN", "Description", "Value", "Footprint"}
+
+// searchParams are the query parameters that are not column filters
+var searchParams = []string{"q", "category", "mfr", "offset", "limit"}
+
+// PartSearchResult is a page of search results. Total is the number of parts
+// that match, of which Parts starts at Offset.
+type PartSearchResult struct {
+	Total  int                `json:"total"`
+	Offset int                `json:"offset"`
+	Limit  int                `json:"limit"`
+	Parts  []KiCadPartSummary `json:"parts"`
+}
+
+// partSearch is a parsed search request
+type partSearch struct {
+	// words must each match one of the searchColumns
+	words []string
+	// category limits results to one category
+	category string
+	// filters map column names to values, one of which must be contained
+	// in the column
+	filters map[string][]string
+	offset  int
+	limit   int
+}
+
+// parsePartSearch parses the query parameters of a search request. mfr is a
+// filter on the Manufacturer column, and all parameters other than q,
+// category, offset, and limit are filters on the column of the same name.
+// isColumn reports whether a column is in the partmaster; other parameters
+// are an error, so a parameter like a cache buster does not match nothing.
+func parsePartSearch(values url.Values, isColumn func(string) bool) (partSearch, error) {
+	search := partSearch{
+		words:    strings.Fields(strings.ToLower(values.Get("q"))),
+		category: strings.ToUpper(strings.TrimSpace(values.Get("category"))),
+		filters:  make(map[string][]string),
+		limit:    searchDefaultLimit,
+	}
+
+	if mfr := values["mfr"]; len(mfr) > 0 {
+		search.filters["Manufacturer"] = mfr
+	}
+
+	for name, v := range values {
+		if lo.Contains(searchParams, name) {
+			continue
+		}
+		if !isColumn(name) {
+			return search, &apiError{http.StatusBadRequest, "unknown search parameter: " + name}
+		}
+		search.filters[name] = append(search.filters[name], v...)
+	}
+
+	var err error
+	if v := values.Get("offset"); v != "" {
+		search.offset, err = strconv.Atoi(v)
+		if err != nil || search.offset < 0 {
+			return search, &apiError{http.StatusBadRequest, "invalid offset: " + v}
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		search.limit, err = strconv.Atoi(v)
+		if err != nil || search.limit <= 0 {
+			return search, &apiError{http.StatusBadRequest, "invalid limit: " + v}
+		}
+		if search.limit > searchMaxLimit {
+			search.limit = searchMaxLimit
+		}
+	}
+
+	return search, nil
+}
+
+// hasColumn returns true if any partmaster file has the column
+func (s *KiCadServer) hasColumn(column string) bool {
+	for _, file := range s.collection().Files {
+		if findColumn(file.Headers, column) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// searchRows returns the rows of parts with an IPN that may match a search.
+// With the cache, only parts in the category that contain the words that can
+// not match with an edit are returned; other rows are scored and skipped by
+// searchParts.
+func (s *KiCadServer) searchRows(search partSearch) []cachedRow {
+	exact := lo.Filter(search.words, func(w string, _ int) bool {
+		return len(w) < fuzzyWordLen
+	})
+	if rows, ok := s.cacheQuery(func(c *partCache) ([]partRef, error) {
+		return c.searchRefs(search.category, exact)
+	}); ok {
+		return rows
+	}
+
+	var rows []cachedRow
+	for _, file := range s.collection().Files {
+		for i := range file.Rows {
+			rows = append(rows, cachedRow{file, i})
+		}
+	}
+	return rows
+}
+
+// searchParts returns the parts matching a search, best matches first. A part
+// matches if every word in the query matches one of the searchColumns, and
+// every filter matches, in any of its sources. Obsolete parts are not
+// included.
+func (s *KiCadServer) searchParts(search partSearch) PartSearchResult {
+	// group rows by IPN, keeping the order of the rows
+	var ipns []string
+	parts := make(map[string][]cachedRow)
+	for _, r := range s.searchRows(search) {
+		id := s.cell(r.file, r.file.Rows[r.row], "IPN")
+		if id == "" {
+			continue
+		}
+		if search.category != "" && s.extractCategory(id) != search.category {
+			continue
+		}
+		if parts[id] == nil {
+			ipns = append(ipns, id)
+		}
+		parts[id] = append(parts[id], r)
+	}
+
+	type match struct {
+		ipn   string
+		row   cachedRow
+		score int
+	}
+	var matches []match
+
+	for _, id := range ipns {
+		rows := parts[id]
+		sort.SliceStable(rows, func(i, j int) bool {
+			return s.rowPriority(rows[i]) < s.rowPriority(rows[j])
+		})
+		preferred := rows[0]
+		if s.isObsolete(preferred.file, preferred.file.Rows[preferred.row]) {
+			continue
+		}
+
+		score, ok := s.searchScore(search, rows)
+		if ok {
+			matches = append(matches, match{id, preferred, score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].ipn < matches[j].ipn
+	})
+
+	result := PartSearchResult{
+		Total:  len(matches),
+		Offset: search.offset,
+		Limit:  search.limit,
+		Parts:  []KiCadPartSummary{},
+	}
+	for i := search.offset; i < len(matches) && i < search.offset+search.limit; i++ {
+		m := matches[i]
+		row := m.row.file.Rows[m.row.row]
+		result.Parts = append(result.Parts, s.partSummary(m.row.file, row, s.extractCategory(m.ipn), i))
+	}
+
+	return result
+}
+
+// searchScore returns the score of a part for a search, and false if the part
+// does not match. The score of each word is its best match in the rows of the
+// part.
+func (s *KiCadServer) searchScore(search partSearch, rows []cachedRow) (int, bool) {
+	for column, values := range search.filters {
+		found := false
+		for _, r := range rows {
+			if findColumn(r.file.Headers, column) < 0 {
+				continue
+			}
+			cell := strings.ToLower(s.cell(r.file, r.file.Rows[r.row], column))
+			for _, v := range values {
+				if strings.Contains(cell, strings.ToLower(strings.TrimSpace(v))) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+
+	total := 0
+	for _, word := range search.words {
+		best := 0
+		for _, r := range rows {
+			for _, column := range searchColumns {
+				cell := s.cell(r.file, r.file.Rows[r.row], column)
+				if score := wordScore(word, strings.ToLower(cell)); score > best {
+					best = score
+				}
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		total += best
+	}
+
+	return total, true
+}
+
+// wordScore returns how well a query word matches a lower case value: 4 if it
+// is a word in the value, 3 if it starts a word, 2 if the value contains it,
+// 1 if it is within one edit of a word in the value, and 0 if it does not
+// match. Words are separated by anything but letters and digits, so 0603
+// matches R_0603_1608Metric. Only words of 4 or more characters are matched
+// with an edit, as short words like 10k would match too many values.
+func wordScore(word, value string) int {
+	if value == "" {
+		return 0
+	}
+
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	best := 0
+	for _, f := range fields {
+		switch {
+		case f == word:
+			return 4
+		case strings.HasPrefix(f, word):
+			best = max(best, 3)
+		}
+	}
+	if best > 0 {
+		return best
+	}
+
+	if strings.Contains(value, word) {
+		return 2
+	}
+
+	if len(word) >= fuzzyWordLen {
+		for _, f := range fields {
+			if editDistance(word, f, 1) <= 1 {
+				return 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// editDistance returns the Levenshtein distance between two strings. The
+// search stops once the distance is greater than limit, and limit+1 is
+// returned.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+
+	return min(prev[len(rb)], limit+1)
+}
+
+// searchHandler handles the search endpoint
+func (s *KiCadServer) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if s.authorize(w, r) == nil {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	search, err := parsePartSearch(r.URL.Query(), s.hasColumn)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.searchParts(search))
+}
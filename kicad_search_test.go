@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestWordScore(t *testing.T) {
+	tests := []struct {
+		word  string
+		value string
+		exp   int
+	}{
+		{"10k", "10k 1% 0603", 4},
+		{"0603", "resistor_smd:r_0603_1608metric", 4},
+		{"rc06", "rc0603fr-0710kl", 3},
+		{"0710", "rc0603fr-0710kl", 3},
+		{"603fr", "rc0603fr-0710kl", 2},
+		{"yago", "yageo", 1},
+		{"resistr", "thick film resistor", 1},
+		{"10k", "100k", 0},
+		{"10k", "1k", 0},
+		{"ceramic", "", 0},
+	}
+
+	for _, test := range tests {
+		if got := wordScore(test.word, test.value); got != test.exp {
+			t.Errorf("wordScore(%q, %q): expected %v, got %v", test.word, test.value, test.exp, got)
+		}
+	}
+}
+
+func TestSearchParts(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"res.csv": "IPN,Description,Value,Footprint,Manufacturer,MPN,Priority,Tolerance\n" +
+			"RES-000-0001,resistor array,10k0,Resistor_SMD:R_Array_0402,Yageo,YC124-FR-0710KL,,1%\n" +
+			"RES-001-0001,10k 1% resistor,10k,Resistor_SMD:R_0603_1608Metric,Yageo,RC0603FR-0710KL,1,1%\n" +
+			"RES-001-0001,10k 1% resistor,10k,Resistor_SMD:R_0603_1608Metric,Vishay,CRCW060310K0FKEA,2,1%\n" +
+			"RES-002-0001,10k 5% resistor,10k,Resistor_SMD:R_0805_2012Metric,Vishay,CRCW080510K0JNEA,,5%\n" +
+			"RES-003-0001,100k 1% resistor,100k,Resistor_SMD:R_0603_1608Metric,Yageo,RC0603FR-07100KL,,1%\n",
+		"cap.csv": "IPN,Description,Value,Footprint,Manufacturer,MPN\n" +
+			"CAP-001-0001,10uF 0603 capacitor,10uF,Capacitor_SMD:C_0603_1608Metric,Murata,GRM188R61A106KE69\n",
+	})
+
+	search := func(query string) PartSearchResult {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.searchHandler(w, httptest.NewRequest(http.MethodGet, "/v1/search?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: expected 200, got %v: %v", query, w.Code, w.Body.String())
+		}
+		var res PartSearchResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	ids := func(res PartSearchResult) []string {
+		var ret []string
+		for _, p := range res.Parts {
+			ret = append(ret, p.ID)
+		}
+		return ret
+	}
+
+	tests := []struct {
+		query string
+		exp   []string
+		total int
+	}{
+		{"q=10k+0603", []string{"RES-001-0001"}, 1},
+		{"q=0603", []string{"CAP-001-0001", "RES-001-0001", "RES-003-0001"}, 3},
+		{"q=0603&category=res", []string{"RES-001-0001", "RES-003-0001"}, 2},
+		{"q=0603&mfr=yageo", []string{"RES-001-0001", "RES-003-0001"}, 2},
+		// whole words rank above word prefixes
+		{"q=10k", []string{"RES-001-0001", "RES-002-0001", "RES-000-0001"}, 3},
+		{"q=10uf", []string{"CAP-001-0001"}, 1},
+		// any source of a part matches
+		{"q=crcw0603", []string{"RES-001-0001"}, 1},
+		{"q=resistr+0805", []string{"RES-002-0001"}, 1},
+		{"category=RES&Tolerance=5%25", []string{"RES-002-0001"}, 1},
+		{"category=CAP&Tolerance=1%25", nil, 0},
+		{"category=RES&limit=2&offset=1", []string{"RES-001-0001", "RES-002-0001"}, 4},
+		{"q=inductor", nil, 0},
+	}
+
+	// the cache narrows the parts that are scored, and must not change results
+	for _, cache := range []bool{false, true} {
+		if cache {
+			s.config.Cache = CacheConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "cache.db")}
+			if err := s.enableCache(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.cache.close()
+		}
+
+		for _, test := range tests {
+			res := search(test.query)
+			got := ids(res)
+			if res.Total != test.total || len(got) != len(test.exp) {
+				t.Errorf("cache=%v %v: expected %v (total %v), got %v (total %v)", cache, test.query,
+					test.exp, test.total, got, res.Total)
+				continue
+			}
+			for i := range got {
+				if got[i] != test.exp[i] {
+					t.Errorf("cache=%v %v: expected %v, got %v", cache, test.query, test.exp, got)
+					break
+				}
+			}
+		}
+	}
+
+	for _, query := range []string{"q=10k&limit=x", "q=10k&_=1", "category=RES&Voltage=50V"} {
+		w := httptest.NewRecorder()
+		s.searchHandler(w, httptest.NewRequest(http.MethodGet, "/v1/search?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %v", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultReadTimeout  = 30 * time.Second
+	defaultWriteTimeout = 60 * time.Second
+	defaultIdleTimeout  = 120 * time.Second
+	// shutdownTimeout is how long in-flight requests have to finish when the
+	// server is stopped
+	shutdownTimeout = 30 * time.Second
+	// selfSignedValidity is how long a generated certificate is valid
+	selfSignedValidity = 10 * 365 * 24 * time.Hour
+)
+
+// timeout converts a timeout in seconds from the config. 0 is the default,
+// and a negative value disables the timeout.
+func timeout(seconds int, def time.Duration) time.Duration {
+	switch {
+	case seconds < 0:
+		return 0
+	case seconds == 0:
+		return def
+	default:
+		return time.Duration(seconds) * time.Second
+	}
+}
+
+// routes returns the handler for all endpoints of the server
+func (s *KiCadServer) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	// Serve frontend files if they exist
+	if execPath, err := os.Executable(); err == nil {
+		staticDir := filepath.Join(filepath.Dir(execPath), "frontend", "dist")
+		if _, err := os.Stat(staticDir); err == nil {
+			mux.Handle("/", http.FileServer(http.Dir(staticDir)))
+		}
+	}
+
+	mux.HandleFunc("/v1/", s.rootHandler)
+	mux.HandleFunc("/v1/categories.json", s.categoriesHandler)
+	mux.HandleFunc("/v1/parts.json", s.partsHandler)
+	mux.HandleFunc("/v1/parts/category/", s.partsByCategoryHandler)
+	mux.HandleFunc("/v1/parts/", s.partsRouter)
+	mux.HandleFunc("/v1/search", s.searchHandler)
+
+	// Add a health check endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	return mux
+}
+
+// serve serves requests from a listener until ctx is done, and then shuts
+// down gracefully. Requests in progress, and any write they started, are
+// finished before serve returns. The partmaster is reloaded when CSV files
+// change while serving.
+func (s *KiCadServer) serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.routes(),
+		ReadHeaderTimeout: timeout(s.config.Server.ReadTimeout, defaultReadTimeout),
+		ReadTimeout:       timeout(s.config.Server.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      timeout(s.config.Server.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       timeout(s.config.Server.IdleTimeout, defaultIdleTimeout),
+	}
+
+	// stopWatch stops the watcher and waits for it to exit, so no reload
+	// runs once the cache is closed
+	stopWatch := func() {}
+	if interval := s.config.Server.reloadInterval(); interval > 0 {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			s.watchCSVFiles(interval, stop)
+			close(done)
+		}()
+		stopWatch = func() {
+			close(stop)
+			<-done
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		stopWatch()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down KiCad HTTP Library API server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		srv.Close()
+	}
+
+	stopWatch()
+
+	// a handler that outlived the timeout may still be writing CSV files
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.cache != nil {
+		if cerr := s.cache.close(); cerr != nil {
+			log.Printf("Error closing cache: %v", cerr)
+		}
+	}
+
+	if serr := <-errc; !errors.Is(serr, http.ErrServerClosed) && err == nil {
+		err = serr
+	}
+	return err
+}
+
+// tlsConfig returns the TLS config for the server, or nil to serve HTTP. If
+// tlsSelfSigned is set, a self-signed certificate is generated and written to
+// tlsCert and tlsKey if they do not exist, or kept in memory if they are not
+// set.
+func (c ServerConfig) tlsConfig() (*tls.Config, error) {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return nil, fmt.Errorf("tlsCert and tlsKey must both be set")
+	}
+
+	var cert tls.Certificate
+	switch {
+	case c.TLSCert == "" && !c.TLSSelfSigned:
+		return nil, nil
+	case c.TLSCert == "":
+		certPEM, keyPEM, err := selfSignedCert(c.Address)
+		if err != nil {
+			return nil, err
+		}
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		if c.TLSSelfSigned {
+			if err := writeSelfSignedCert(c.TLSCert, c.TLSKey, c.Address); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		cert, err = tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS certificate: %v", err)
+		}
+	}
+
+	if c.TLSSelfSigned && len(cert.Certificate) > 0 {
+		fp := sha256.Sum256(cert.Certificate[0])
+		log.Printf("Self-signed certificate SHA-256 fingerprint: %v", hex.EncodeToString(fp[:]))
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// writeSelfSignedCert generates a self-signed certificate and writes it to
+// certPath and keyPath, unless both already exist
+func writeSelfSignedCert(certPath, keyPath, address string) error {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+
+	certPEM, keyPEM, err := selfSignedCert(address)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Generating self-signed certificate %v", certPath)
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return fmt.Errorf("error writing TLS key: %v", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return fmt.Errorf("error writing TLS certificate: %v", err)
+	}
+	return nil
+}
+
+// selfSignedCert generates a PEM encoded self-signed certificate and key for
+// localhost, the host name, the addresses of the network interfaces, and the
+// bind address
+func selfSignedCert(address string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"GitPLM"}, CommonName: "gitplm"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	if name, err := os.Hostname(); err == nil && name != "" {
+		tmpl.DNSNames = append(tmpl.DNSNames, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	if address != "" {
+		if ip := net.ParseIP(address); ip == nil {
+			tmpl.DNSNames = append(tmpl.DNSNames, address)
+		} else if !ip.IsUnspecified() {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServe runs serve on a local port and returns its URL, and a function
+// that stops it and returns the error from serve
+func startServe(t *testing.T, s *KiCadServer, tlsConfig *tls.Config) (string, func() error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		url = "https://" + ln.Addr().String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.serve(ctx, ln)
+	}()
+
+	stop := func() error {
+		cancel()
+		select {
+		case err := <-errc:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatal("server did not stop")
+			return nil
+		}
+	}
+	return url, stop
+}
+
+func TestRoutes(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description\nCAP-001-0001,10uF\n",
+	})
+
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	for _, path := range []string{"/health", "/v1/categories.json", "/v1/parts/CAP-001-0001.json"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%v: expected 200, got %v", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description\nCAP-001-0001,10uF\n",
+	})
+	url, stop := startServe(t, s, nil)
+
+	// hold the write lock so the create is in progress when the server stops
+	s.writeMu.Lock()
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(url+"/v1/parts.json", "application/json",
+			strings.NewReader(`{"id":"CAP-001-0002","name":"22uF","category":"CAP"}`))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+
+	// wait for the request to reach the handler
+	time.Sleep(100 * time.Millisecond)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- stop()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	s.writeMu.Unlock()
+
+	if err := <-stopped; err != nil {
+		t.Fatalf("serve returned %v", err)
+	}
+
+	resp := <-done
+	if resp == nil {
+		t.Fatal("no response")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected 201, got %v", resp.StatusCode)
+	}
+	if s.getPartDetail("CAP-001-0002") == nil {
+		t.Error("part created during shutdown was not saved")
+	}
+
+	if _, err := http.Get(url + "/health"); err == nil {
+		t.Error("server still accepts requests after shutdown")
+	}
+}
+
+func TestTLSSelfSigned(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description\nCAP-001-0001,10uF\n",
+	})
+
+	dir := t.TempDir()
+	s.config.Server = ServerConfig{
+		TLSCert:       filepath.Join(dir, "cert.pem"),
+		TLSKey:        filepath.Join(dir, "key.pem"),
+		TLSSelfSigned: true,
+	}
+
+	tlsConfig, err := s.config.Server.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := os.ReadFile(s.config.Server.TLSCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an existing certificate is reused
+	if _, err := s.config.Server.tlsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(s.config.Server.TLSCert); string(again) != string(certPEM) {
+		t.Error("certificate was regenerated")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certPEM) {
+		t.Fatal("invalid certificate")
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	url, stop := startServe(t, s, tlsConfig)
+	defer stop()
+
+	resp, err := client.Get(url + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("expected 200 over TLS, got %v", resp.StatusCode)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	if c, err := (ServerConfig{}).tlsConfig(); c != nil || err != nil {
+		t.Errorf("expected no TLS, got %v, %v", c, err)
+	}
+	if _, err := (ServerConfig{TLSCert: "cert.pem"}).tlsConfig(); err == nil {
+		t.Error("expected error for a certificate without a key")
+	}
+	if c, err := (ServerConfig{TLSSelfSigned: true}).tlsConfig(); c == nil || err != nil {
+		t.Errorf("expected in memory certificate, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultReloadInterval is how often pmDir is checked for changed CSV files
+// if server.reloadInterval is not set
+const defaultReloadInterval = 2 * time.Second
+
+// reloadInterval returns how often the server checks pmDir for changed CSV
+// files, or 0 if it does not
+func (c ServerConfig) reloadInterval() time.Duration {
+	switch {
+	case c.ReloadInterval < 0:
+		return 0
+	case c.ReloadInterval == 0:
+		return defaultReloadInterval
+	default:
+		return time.Duration(c.ReloadInterval) * time.Second
+	}
+}
+
+// csvStamp identifies the version of a file on disk
+type csvStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// scanCSVFiles returns the stamps of the CSV files in a directory
+func scanCSVFiles(dir string) (map[string]csvStamp, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+
+	stamps := make(map[string]csvStamp, len(paths))
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stamps[p] = csvStamp{info.ModTime(), info.Size()}
+	}
+
+	return stamps, nil
+}
+
+// watchCSVFiles checks pmDir every interval, and reloads the partmaster when
+// CSV files are added, removed, or changed, for example by a git pull. Requests
+// are served from the previous snapshot until the new one is loaded. It
+// returns when stop is closed.
+func (s *KiCadServer) watchCSVFiles(interval time.Duration, stop <-chan struct{}) {
+	last, err := scanCSVFiles(s.pmDir)
+	if err != nil {
+		log.Printf("Error checking CSV files: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		stamps, err := scanCSVFiles(s.pmDir)
+		if err != nil {
+			log.Printf("Error checking CSV files: %v", err)
+			continue
+		}
+
+		if maps.EqualFunc(stamps, last, func(a, b csvStamp) bool {
+			return a.modTime.Equal(b.modTime) && a.size == b.size
+		}) {
+			continue
+		}
+
+		log.Printf("CSV files in %v changed, reloading", s.pmDir)
+		if err := s.reloadCSVCollection(); err != nil {
+			// try again at the next check
+			log.Printf("Error reloading CSV files: %v", err)
+			continue
+		}
+		last = stamps
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startWatch watches the CSV files of a server, and returns a function that
+// stops watching and waits for the watcher to return
+func startWatch(t *testing.T, s *KiCadServer) func() {
+	t.Helper()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.watchCSVFiles(10*time.Millisecond, stop)
+		close(done)
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func TestWatchCSVFiles(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description\nCAP-001-0001,10uF\n",
+	})
+
+	stop := startWatch(t, s)
+	defer stop()
+
+	old := s.collection()
+
+	// wait for the first scan before changing files
+	time.Sleep(50 * time.Millisecond)
+
+	err := os.WriteFile(filepath.Join(s.pmDir, "cap.csv"),
+		[]byte("IPN,Description\nCAP-001-0001,10uF\nCAP-001-0002,1uF\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(s.pmDir, "res.csv"),
+		[]byte("IPN,Description\nRES-001-0001,10k\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.getPartDetail("CAP-001-0002") == nil || s.getPartDetail("RES-001-0001") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("changed files were not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the previous snapshot is not modified by the reload
+	if len(old.Files) != 1 || len(old.Files[0].Rows) != 1 {
+		t.Errorf("previous snapshot was modified: %+v", old.Files)
+	}
+}
+
+func TestWatchNoUsableFiles(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"cap.csv": "IPN,Description\nCAP-001-0001,10uF\n",
+	})
+
+	stop := startWatch(t, s)
+	defer stop()
+
+	// wait for the first scan before changing files
+	time.Sleep(50 * time.Millisecond)
+
+	// an editor saving by removing the file and writing it again
+	path := filepath.Join(s.pmDir, "cap.csv")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if s.getPartDetail("CAP-001-0001") == nil {
+		t.Error("loaded files were replaced when no CSV files could be loaded")
+	}
+	if _, err := os.Stat(filepath.Join(s.pmDir, "partmaster.csv")); err == nil {
+		t.Error("reload created partmaster.csv")
+	}
+
+	err := os.WriteFile(path, []byte("IPN,Description\nCAP-001-0002,1uF\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.getPartDetail("CAP-001-0002") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("file was not reloaded after it was written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
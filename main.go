@@ -13,26 +13,87 @@ import (
 var version = "Development"
 
 func main() {
-	initCSV()
-
-	// Load config file first
-	config, err := loadConfig()
-	if err != nil {
-		log.Printf("Error loading config: %v", err)
-		os.Exit(-1)
-	}
-
 	flagRelease := flag.String("release", "", "Process release for IPN (ex: PCB-056-0005, ASY-002-0023)")
 	flagVersion := flag.Bool("version", false, "display version of this application")
 	flagSimplify := flag.String("simplify", "", "simplify a BOM file, combine lines with common MPN")
 	flagOutput := flag.String("out", "", "output file")
 	flagCombine := flag.String("combine", "", "adds BOM to output bom")
-	flagPMDir := flag.String("pmDir", config.PMDir, "specify location of partmaster CSV files")
+	flagPMDir := flag.String("pmDir", "", "specify location of partmaster CSV files (default from config)")
+	flagDir := flag.String("C", "", "run as if gitplm was started in this directory")
+	flagFind := flag.String("find", "", "search the partmaster by IPN, MPN, or description")
+	flagCheckYml := flag.String("check-yml", "", "check a release YML file for errors (ex: PCA-019.yml)")
 	flagHTTPServer := flag.Bool("http", false, "start KiCad HTTP Library API server")
-	flagHTTPPort := flag.Int("port", 8080, "HTTP server port")
+	flagHTTPPort := flag.Int("port", 0, "HTTP server port (default from config or 8080)")
 	flagHTTPToken := flag.String("token", "", "authentication token for HTTP API")
+	flagHTTPBind := flag.String("bind", "", "HTTP server bind address (default from config or all interfaces)")
+	flagTLSCert := flag.String("tls-cert", "", "TLS certificate file for the HTTP server")
+	flagTLSKey := flag.String("tls-key", "", "TLS key file for the HTTP server")
+	flagTLSSelfSigned := flag.Bool("tls-self-signed", false, "serve HTTPS with a self-signed certificate, written to -tls-cert and -tls-key if they do not exist")
 	flag.Parse()
 
+	if *flagDir != "" {
+		err := os.Chdir(*flagDir)
+		if err != nil {
+			log.Printf("Error changing to directory %v: %v", *flagDir, err)
+			os.Exit(-1)
+		}
+	}
+
+	root, err := findWorkspaceRoot()
+	if err != nil {
+		log.Printf("Error finding workspace root: %v", err)
+		os.Exit(-1)
+	}
+
+	config, err := loadConfig(root)
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+		os.Exit(-1)
+	}
+
+	configureWorkspace(root, config.SourceRoots)
+
+	err = setCSVConfig(config.CSV)
+	if err != nil {
+		log.Printf("Error in config: %v", err)
+		os.Exit(-1)
+	}
+
+	initCSV()
+
+	// command line flags override config settings
+	if *flagPMDir != "" {
+		config.PMDir = *flagPMDir
+	}
+
+	if *flagHTTPPort != 0 {
+		config.Server.Port = *flagHTTPPort
+	}
+
+	if config.Server.Port == 0 {
+		config.Server.Port = 8080
+	}
+
+	if *flagHTTPToken != "" {
+		config.Server.Token = *flagHTTPToken
+	}
+
+	if *flagHTTPBind != "" {
+		config.Server.Address = *flagHTTPBind
+	}
+
+	if *flagTLSCert != "" {
+		config.Server.TLSCert = *flagTLSCert
+	}
+
+	if *flagTLSKey != "" {
+		config.Server.TLSKey = *flagTLSKey
+	}
+
+	if *flagTLSSelfSigned {
+		config.Server.TLSSelfSigned = true
+	}
+
 	if *flagVersion {
 		if version == "" {
 			version = "Development"
@@ -121,8 +182,36 @@ func main() {
 		return
 	}
 
+	if *flagFind != "" {
+		err := findCommand(config, *flagFind)
+		if err != nil {
+			log.Printf("Error searching partmaster: %v", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	if *flagCheckYml != "" {
+		problems, err := checkRelScript(*flagCheckYml, config.TemplatesDir)
+		if err != nil {
+			log.Printf("Error checking %v: %v", *flagCheckYml, err)
+			os.Exit(-1)
+		}
+
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+
+		if len(problems) > 0 {
+			os.Exit(-1)
+		}
+
+		fmt.Printf("%v: OK\n", *flagCheckYml)
+		return
+	}
+
 	if *flagRelease != "" {
-		relPath, err := processRelease(*flagRelease, &gLog, *flagPMDir)
+		relPath, err := processRelease(*flagRelease, &gLog, config)
 		if err != nil {
 			logMsg(fmt.Sprintf("release error: %v\n", err))
 		} else {
@@ -148,19 +237,19 @@ func main() {
 
 	// Start HTTP server if requested
 	if *flagHTTPServer {
-		if *flagPMDir == "" {
+		if config.PMDir == "" {
 			log.Fatal("Error: partmaster directory not specified. Use -pmDir flag or configure gitplm.yml")
 		}
-		
+
 		log.Printf("Starting KiCad HTTP Library API server...")
-		log.Printf("Partmaster directory: %s", *flagPMDir)
-		if *flagHTTPToken != "" {
-			log.Printf("Authentication enabled with token")
+		log.Printf("Partmaster directory: %s", config.PMDir)
+		if config.Server.Token != "" || config.Server.UsersFile != "" {
+			log.Printf("Authentication enabled")
 		} else {
-			log.Printf("No authentication token specified - server will be open")
+			log.Printf("No authentication token or users file specified - server will be open")
 		}
-		
-		err := StartKiCadServer(*flagPMDir, *flagHTTPToken, *flagHTTPPort)
+
+		err := StartKiCadServer(config)
 		if err != nil {
 			log.Fatal("Error starting HTTP server: ", err)
 		}
@@ -168,8 +257,8 @@ func main() {
 	}
 
 	// If no flags were provided, show the TUI
-	if len(os.Args) == 1 {
-		err := runTUINew(*flagPMDir)
+	if flag.NFlag() == 0 || (flag.NFlag() == 1 && *flagDir != "") {
+		err := runTUINew(config)
 		if err != nil {
 			log.Fatal("Error running TUI: ", err)
 		}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	_ "modernc.org/sqlite"
+)
+
+// partCache is an SQLite index of the partmaster CSV files. The CSV files are
+// the source of truth and are still loaded in memory; the cache only stores
+// where each part is (file and row) along with the columns that are queried,
+// so lookups do not scan every row, and is updated when a file changes.
+type partCache struct {
+	db *sql.DB
+}
+
+// partRef is the location of a part row in a CSV file collection
+type partRef struct {
+	File string
+	Row  int
+}
+
+// partCacheVersion is increased when the schema changes, so caches created by
+// other versions are rebuilt
+const partCacheVersion = 3
+
+const partCacheSchema = `
+CREATE TABLE IF NOT EXISTS files (
+	name TEXT PRIMARY KEY,
+	hash TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS parts (
+	id INTEGER PRIMARY KEY,
+	file TEXT NOT NULL,
+	row INTEGER NOT NULL,
+	ipn TEXT NOT NULL,
+	category TEXT NOT NULL,
+	mpn TEXT NOT NULL,
+	manufacturer TEXT NOT NULL,
+	-- description is lower case, for find
+	description TEXT NOT NULL,
+	-- search is the lower case text of the searchColumns
+	search TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS parts_file ON parts(file, row);
+CREATE INDEX IF NOT EXISTS parts_ipn ON parts(ipn);
+CREATE INDEX IF NOT EXISTS parts_mpn ON parts(mpn COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS parts_category ON parts(category);
+`
+
+// defaultPartCachePath returns the cache file for a partmaster directory in
+// the user cache directory
+func defaultPartCachePath(pmDir string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	absPMDir, err := filepath.Abs(pmDir)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(absPMDir))
+	return filepath.Join(cacheDir, "gitplm", hex.EncodeToString(sum[:8])+".db"), nil
+}
+
+// openPartCache opens or creates a cache database
+func openPartCache(path string) (*partCache, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error reading cache version in %v: %v", path, err)
+	}
+
+	schema := partCacheSchema
+	if version != partCacheVersion {
+		schema = `
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS parts;
+DROP TABLE IF EXISTS parts_fts;
+` + schema + fmt.Sprintf("PRAGMA user_version = %d;\n", partCacheVersion)
+	}
+
+	_, err = db.Exec(schema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating cache schema in %v: %v", path, err)
+	}
+
+	return &partCache{db: db}, nil
+}
+
+// loadPartCache opens the cache configured for a partmaster directory and
+// updates it from the collection. Returns nil if the cache is not enabled.
+func loadPartCache(config *Config, collection *CSVFileCollection) (*partCache, error) {
+	if !config.Cache.Enabled {
+		return nil, nil
+	}
+
+	path := config.Cache.Path
+	if path == "" {
+		var err error
+		path, err = defaultPartCachePath(config.PMDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := openPartCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.sync(collection)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *partCache) close() error {
+	return c.db.Close()
+}
+
+// fileHash returns the hash of a file as it was loaded, or "" if unknown
+func fileHash(file *CSVFile) string {
+	if file.state == nil {
+		return ""
+	}
+	return hex.EncodeToString(file.state.hash[:])
+}
+
+var reCategory = regexp.MustCompile(`^([A-Z][A-Z][A-Z])-\d\d\d-\d\d\d\d$`)
+
+// partCategory returns the category of a row: the CCC of the IPN, or the file
+// name for rows without an IPN
+func partCategory(file *CSVFile, ipn string) string {
+	if ipn != "" {
+		m := reCategory.FindStringSubmatch(ipn)
+		if m == nil {
+			return ""
+		}
+		return m[1]
+	}
+
+	name := strings.TrimSuffix(strings.ToUpper(file.Name), ".CSV")
+	if len(name) == 3 {
+		return name
+	}
+	return ""
+}
+
+// sync updates the cache for files that were added, changed, or removed since
+// the last sync
+func (c *partCache) sync(collection *CSVFileCollection) error {
+	cached := make(map[string]string)
+	rows, err := c.db.Query(`SELECT name, hash FROM files`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name, hash string
+		if err := rows.Scan(&name, &hash); err != nil {
+			rows.Close()
+			return err
+		}
+		cached[name] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, file := range collection.Files {
+		hash := fileHash(file)
+		prev, ok := cached[file.Name]
+		delete(cached, file.Name)
+		if ok && hash != "" && hash == prev {
+			continue
+		}
+
+		if err := deleteCachedFile(tx, file.Name); err != nil {
+			return err
+		}
+
+		if err := insertCachedFile(tx, file, hash); err != nil {
+			return err
+		}
+	}
+
+	for name := range cached {
+		if err := deleteCachedFile(tx, name); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func deleteCachedFile(tx *sql.Tx, name string) error {
+	_, err := tx.Exec(`DELETE FROM parts WHERE file = ?`, name)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM files WHERE name = ?`, name)
+	return err
+}
+
+func insertCachedFile(tx *sql.Tx, file *CSVFile, hash string) error {
+	_, err := tx.Exec(`INSERT INTO files (name, hash) VALUES (?, ?)`, file.Name, hash)
+	if err != nil {
+		return err
+	}
+
+	ipnIdx := findColumn(file.Headers, "IPN")
+	mpnIdx := findColumn(file.Headers, "MPN")
+	mfrIdx := findColumn(file.Headers, "Manufacturer")
+	descIdx := findColumn(file.Headers, "Description")
+
+	cell := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	var searchIdxs []int
+	for _, c := range searchColumns {
+		if i := findColumn(file.Headers, c); i >= 0 {
+			searchIdxs = append(searchIdxs, i)
+		}
+	}
+
+	insertPart, err := tx.Prepare(`INSERT INTO parts
+		(file, row, ipn, category, mpn, manufacturer, description, search)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertPart.Close()
+
+	for i, row := range file.Rows {
+		if len(row) == 0 {
+			continue
+		}
+		ipn := cell(row, ipnIdx)
+		var search []string
+		for _, idx := range searchIdxs {
+			search = append(search, strings.ToLower(cell(row, idx)))
+		}
+		_, err := insertPart.Exec(file.Name, i, ipn, partCategory(file, ipn),
+			cell(row, mpnIdx), cell(row, mfrIdx), strings.ToLower(cell(row, descIdx)),
+			strings.Join(search, "\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryRefs runs a query that returns file and row columns
+func (c *partCache) queryRefs(query string, args ...any) ([]partRef, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []partRef
+	for rows.Next() {
+		var r partRef
+		if err := rows.Scan(&r.File, &r.Row); err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+
+	return refs, rows.Err()
+}
+
+// findIPN returns all rows for an IPN
+func (c *partCache) findIPN(ipn string) ([]partRef, error) {
+	return c.queryRefs(`SELECT file, row FROM parts WHERE ipn = ? ORDER BY file, row`, ipn)
+}
+
+// findMPN returns all rows for an MPN, ignoring case
+func (c *partCache) findMPN(mpn string) ([]partRef, error) {
+	return c.queryRefs(`SELECT file, row FROM parts WHERE mpn = ? COLLATE NOCASE ORDER BY file, row`, mpn)
+}
+
+// byCategory returns all rows in a category
+func (c *partCache) byCategory(category string) ([]partRef, error) {
+	return c.queryRefs(`SELECT file, row FROM parts WHERE category = ? ORDER BY file, row`, category)
+}
+
+// search returns rows where the IPN or MPN starts with the query, or the
+// description contains all words in the query, ignoring case. The matches are
+// the same as searchCollection.
+func (c *partCache) search(query string) ([]partRef, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, nil
+	}
+
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+
+	var words []string
+	args := []any{prefix, prefix}
+	for _, w := range strings.Fields(query) {
+		words = append(words, `instr(description, ?) > 0`)
+		args = append(args, w)
+	}
+
+	return c.queryRefs(`
+		SELECT file, row FROM parts
+		WHERE ipn LIKE ? ESCAPE '\' OR mpn LIKE ? ESCAPE '\' OR (`+strings.Join(words, " AND ")+`)
+		ORDER BY file, row`, args...)
+}
+
+// searchRefs returns the rows of the parts that may match a search of the
+// search endpoint, which scores them: all rows of parts in category, if it is
+// set, where each word is contained in the searchColumns of a row of the part
+func (c *partCache) searchRefs(category string, words []string) ([]partRef, error) {
+	query := `SELECT file, row FROM parts WHERE ipn != ''`
+	var args []any
+	if category != "" {
+		query += ` AND category = ?`
+		args = append(args, category)
+	}
+	for _, w := range words {
+		query += ` AND ipn IN (SELECT ipn FROM parts WHERE instr(search, ?) > 0)`
+		args = append(args, strings.ToLower(w))
+	}
+	return c.queryRefs(query+` ORDER BY file, row`, args...)
+}
+
+// file returns the file in the collection with the given name
+func (c *CSVFileCollection) file(name string) *CSVFile {
+	for _, f := range c.Files {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// searchCollection is the search used when the cache is not enabled. Rows
+// match if the IPN or MPN starts with the query, or the description contains
+// all words in the query, ignoring case.
+func searchCollection(collection *CSVFileCollection, query string) []partRef {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+	words := strings.Fields(query)
+
+	var refs []partRef
+	for _, file := range collection.Files {
+		ipnIdx := findColumn(file.Headers, "IPN")
+		mpnIdx := findColumn(file.Headers, "MPN")
+		descIdx := findColumn(file.Headers, "Description")
+
+		cell := func(row []string, i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.ToLower(row[i])
+		}
+
+		for i, row := range file.Rows {
+			match := strings.HasPrefix(cell(row, ipnIdx), query) ||
+				strings.HasPrefix(cell(row, mpnIdx), query)
+
+			if !match {
+				desc := cell(row, descIdx)
+				match = true
+				for _, w := range words {
+					if !strings.Contains(desc, w) {
+						match = false
+						break
+					}
+				}
+			}
+
+			if match {
+				refs = append(refs, partRef{file.Name, i})
+			}
+		}
+	}
+
+	return refs
+}
+
+// findParts searches the partmaster using the cache if it is enabled
+func findParts(collection *CSVFileCollection, cache *partCache, query string) ([]partRef, error) {
+	if cache != nil {
+		return cache.search(query)
+	}
+	return searchCollection(collection, query), nil
+}
+
+// findCommand prints the partmaster rows matching a query
+func findCommand(config *Config, query string) error {
+	if config.PMDir == "" {
+		return fmt.Errorf("partmaster directory not specified, use -pmDir or configure gitplm.yml")
+	}
+
+	collection, err := loadAllCSVFiles(config.PMDir)
+	if err != nil {
+		return err
+	}
+
+	cache, err := loadPartCache(config, collection)
+	if err != nil {
+		return err
+	}
+	if cache != nil {
+		defer cache.close()
+	}
+
+	refs, err := findParts(collection, cache, query)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IPN\tManufacturer\tMPN\tDescription")
+	for _, r := range refs {
+		file := collection.file(r.File)
+		if file == nil || r.Row >= len(file.Rows) {
+			continue
+		}
+		row := file.Rows[r.Row]
+		cell := func(column string) string {
+			i := findColumn(file.Headers, column)
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return row[i]
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", cell("IPN"), cell("Manufacturer"), cell("MPN"), cell("Description"))
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPartCache(t *testing.T) {
+	pmDir := t.TempDir()
+
+	files := map[string]string{
+		"cap.csv": `IPN,Description,Manufacturer,MPN
+CAP-001-1001,Ceramic capacitor 10uF 16V X5R,Murata,GRM188R61C106
+CAP-001-1001,Ceramic capacitor 10uF 16V X5R,Samsung,CL10A106KO8
+CAP-002-0001,Electrolytic capacitor 100uF,Panasonic,EEE-FK1C101P
+`,
+		"res.csv": `IPN,Description,Manufacturer,MPN
+RES-001-1002,Resistor 10k 1% 0603,Yageo,RC0603FR-0710KL
+`,
+	}
+	for n, c := range files {
+		if err := os.WriteFile(filepath.Join(pmDir, n), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collection, err := loadAllCSVFiles(pmDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		PMDir: pmDir,
+		Cache: CacheConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "cache.db")},
+	}
+
+	cache, err := loadPartCache(config, collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.close()
+
+	check := func(name string, refs []partRef, err error, exp []partRef) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if !reflect.DeepEqual(refs, exp) {
+			t.Errorf("%v: expected %v, got %v", name, exp, refs)
+		}
+	}
+
+	refs, err := cache.findIPN("CAP-001-1001")
+	check("ipn", refs, err, []partRef{{"cap.csv", 0}, {"cap.csv", 1}})
+
+	refs, err = cache.findMPN("cl10a106ko8")
+	check("mpn", refs, err, []partRef{{"cap.csv", 1}})
+
+	refs, err = cache.byCategory("RES")
+	check("category", refs, err, []partRef{{"res.csv", 0}})
+
+	queries := map[string][]partRef{
+		"10uF x5r":  {{"cap.csv", 0}, {"cap.csv", 1}},
+		"capacitor": {{"cap.csv", 0}, {"cap.csv", 1}, {"cap.csv", 2}},
+		"RC0603":    {{"res.csv", 0}},
+		"CAP-002":   {{"cap.csv", 2}},
+		// words match anywhere in the description, with and without the cache
+		"603":          {{"res.csv", 0}},
+		"acitor 100uf": {{"cap.csv", 2}},
+		"rc0603fr":     {{"res.csv", 0}},
+		"cap-001-1001": {{"cap.csv", 0}, {"cap.csv", 1}},
+		"50%":          nil,
+		`10k "quoted`:  nil,
+		"nothing here": nil,
+	}
+	for q, exp := range queries {
+		refs, err = cache.search(q)
+		check("search "+q, refs, err, exp)
+
+		check("collection search "+q, searchCollection(collection, q), nil, exp)
+	}
+
+	// rows of parts that may match the search endpoint
+	refs, err = cache.searchRefs("CAP", []string{"x5r"})
+	check("search refs", refs, err, []partRef{{"cap.csv", 0}, {"cap.csv", 1}})
+
+	refs, err = cache.searchRefs("", []string{"samsung"})
+	check("search refs other column", refs, err, nil)
+
+	refs, err = cache.searchRefs("", []string{"cl10", "ceramic"})
+	check("search refs any source", refs, err, []partRef{{"cap.csv", 0}, {"cap.csv", 1}})
+
+	// change a file and make sure only the new data is found
+	err = os.WriteFile(filepath.Join(pmDir, "res.csv"), []byte(`IPN,Description,Manufacturer,MPN
+RES-001-1003,Resistor 100k 1% 0603,Yageo,RC0603FR-07100KL
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(pmDir, "cap.csv")); err != nil {
+		t.Fatal(err)
+	}
+
+	collection, err = loadAllCSVFiles(pmDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.sync(collection); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err = cache.findIPN("RES-001-1002")
+	check("removed ipn", refs, err, nil)
+
+	refs, err = cache.search("100k")
+	check("new row", refs, err, []partRef{{"res.csv", 0}})
+
+	refs, err = cache.search("capacitor")
+	check("removed file", refs, err, nil)
+}
+
+func TestPartCacheVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	// a cache with a schema from another version is rebuilt
+	cache, err := openPartCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.db.Exec(`DROP TABLE parts; CREATE TABLE parts (id INTEGER PRIMARY KEY, file TEXT);
+		INSERT INTO files (name, hash) VALUES ('cap.csv', 'old'); PRAGMA user_version = 1;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.close()
+
+	cache, err = openPartCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.close()
+
+	var files int
+	if err := cache.db.QueryRow(`SELECT count(*) FROM files`).Scan(&files); err != nil {
+		t.Fatal(err)
+	}
+	if files != 0 {
+		t.Errorf("expected an empty cache, got %v files", files)
+	}
+	if _, err := cache.searchRefs("", []string{"10k"}); err != nil {
+		t.Errorf("search on rebuilt cache: %v", err)
+	}
+}
@@ -16,6 +16,8 @@ type partmasterLine struct {
 	Datasheet    string `csv:"Datasheet"`
 	Priority     int    `csv:"Priority"`
 	Checked      string `csv:"Checked"`
+	// Extra holds columns that are not listed above
+	Extra csvExtra `csv:"-"`
 }
 
 func (p *partmasterLine) String() string {
@@ -115,11 +117,6 @@ func loadPartmasterFromDir(dir string) (partmaster, error) {
 			return pm, err
 		}
 		files = []string{csvFile.Path}
-		_, err := createBlankPartmasterCSV(dir)
-		if err != nil {
-			return pm, err
-		}
-		files = []string{filepath.Join(dir, "partmaster.csv")}
 	}
 
 	for _, file := range files {
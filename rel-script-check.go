@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+var reYamlLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// decode strictly decodes a release script. Unknown fields are recorded as
+// problems, syntax errors are returned as an error. The document node is
+// returned so problems can be reported with line numbers.
+func (l *relScriptLoader) decode(filePath string, data []byte) (*relScript, *yaml.Node, error) {
+	rs := &relScript{}
+	doc := &yaml.Node{}
+
+	err := yaml.Unmarshal(data, doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing %v: %v", filePath, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(rs)
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		for _, e := range typeErr.Errors {
+			if m := reYamlLine.FindStringSubmatch(e); m != nil {
+				l.problems = append(l.problems, fmt.Sprintf("%v:%v: %v", filePath, m[1], m[2]))
+			} else {
+				l.problems = append(l.problems, fmt.Sprintf("%v: %v", filePath, e))
+			}
+		}
+	} else if err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("Error parsing %v: %v", filePath, err)
+	}
+
+	return rs, doc, nil
+}
+
+// check validates the fields of a single release script file
+func (l *relScriptLoader) check(filePath string, doc *yaml.Node, rs *relScript) {
+	report := func(key string, i int, format string, args ...any) {
+		l.problems = append(l.problems, fmt.Sprintf("%v:%v: %v: %v",
+			filePath, seqItemLine(doc, key, i), key, fmt.Sprintf(format, args...)))
+	}
+
+	checkTemplate := func(key string, i int, text string) {
+		_, err := template.New("relScript").Parse(text)
+		if err != nil {
+			report(key, i, "invalid template %q: %v", text, err)
+		}
+	}
+
+	checkPattern := func(key string, i int, pattern string) {
+		_, err := filepath.Match(pattern, "")
+		if err != nil {
+			report(key, i, "invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	for i, inc := range rs.Include {
+		if strings.TrimSpace(inc) == "" {
+			report("include", i, "path is empty")
+		}
+	}
+
+	for i, r := range rs.Remove {
+		if r.CmpName == "" && r.Ref == "" {
+			report("remove", i, "entry must specify cmpName or ref")
+		}
+	}
+
+	for i, a := range rs.Add {
+		_, err := newIpn(a.IPN.String())
+		if err != nil {
+			report("add", i, "invalid IPN %q", a.IPN)
+		}
+
+		// qty defaults to the number of refs, or 1 if there are none
+		if a.Qty < 0 {
+			report("add", i, "qty must not be negative")
+		}
+
+		if a.Ref != "" && len(parseRefs(a.Ref)) == 0 {
+			report("add", i, "no references in %q", a.Ref)
+		}
+	}
+
+	for i, c := range rs.Copy {
+		if c.Src == "" {
+			report("copy", i, "entry must specify src")
+			continue
+		}
+
+		checkTemplate("copy", i, c.Src)
+		checkTemplate("copy", i, c.Dest)
+		checkPattern("copy", i, c.Src)
+		for _, e := range c.Exclude {
+			checkPattern("copy", i, e)
+		}
+
+		if l.srcDir == "" || strings.Contains(c.Src, "{{") {
+			continue
+		}
+
+		baseDir := l.srcDir
+		if c.From != "" {
+			var err error
+			baseDir, err = findDir(c.From)
+			if err != nil {
+				report("copy", i, "%v", err)
+				continue
+			}
+		}
+
+		p := filepath.Join(baseDir, c.Src)
+		matches, err := filepath.Glob(p)
+		if err == nil && len(matches) == 0 {
+			report("copy", i, "source does not exist: %v", p)
+		}
+	}
+
+	for i, h := range rs.Hooks {
+		checkTemplate("hooks", i, h)
+	}
+
+	for i, r := range rs.Required {
+		if r.File == "" {
+			report("required", i, "entry must specify file")
+			continue
+		}
+
+		checkPattern("required", i, r.File)
+
+		if r.MinSize < 0 {
+			report("required", i, "minSize must not be negative")
+		}
+
+		if r.MinCount < 0 {
+			report("required", i, "minCount must not be negative")
+		}
+	}
+}
+
+// checkRelScript validates a release script and all files it includes,
+// and returns a list of problems in file:line: message format. Copy sources
+// are checked relative to the directory of the release script.
+func checkRelScript(filePath, templatesDir string) ([]string, error) {
+	l := relScriptLoader{
+		templatesDir: templatesDir,
+		srcDir:       filepath.Dir(filePath),
+	}
+
+	_, err := l.load(filePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.problems, nil
+}
+
+// seqItemLine returns the line of item i in the sequence stored under key
+// in the top level mapping of doc. If the item can't be found, the closest
+// line available is returned.
+func seqItemLine(doc *yaml.Node, key string, i int) int {
+	n := doc
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	if n.Kind != yaml.MappingNode {
+		return n.Line
+	}
+
+	for j := 0; j+1 < len(n.Content); j += 2 {
+		if n.Content[j].Value == key {
+			v := n.Content[j+1]
+			if v.Kind == yaml.SequenceNode && i < len(v.Content) {
+				return v.Content[i].Line
+			}
+			return v.Line
+		}
+	}
+
+	return n.Line
+}
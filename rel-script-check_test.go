@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var checkFile = `description: bad script
+requried:
+ - fab.pdf
+remove:
+ - cmp_name: Test point
+add:
+ - cmpName: screw
+   ref: S3
+   ipn: SCR-02-0002
+ - cmpName: glue
+   ipn: MCH-001-0001
+ - cmpName: spacer
+   ipn: MCH-001-0002
+   qty: -1
+copy:
+ - gerber
+ - src: "fab/[.pdf"
+hooks:
+ - echo {{ .IPN }
+`
+
+func TestCheckRelScript(t *testing.T) {
+	srcDir := t.TempDir()
+	ymlPath := filepath.Join(srcDir, "PCA-019.yml")
+
+	err := os.WriteFile(ymlPath, []byte(checkFile), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := checkRelScript(ymlPath, "")
+	if err != nil {
+		t.Fatalf("error checking rel script: %v", err)
+	}
+
+	exp := []string{
+		ymlPath + ":2: field requried not found",
+		ymlPath + ":5: field cmp_name not found",
+		ymlPath + ":5: remove: entry must specify cmpName or ref",
+		ymlPath + `:7: add: invalid IPN "SCR-02-0002"`,
+		ymlPath + ":12: add: qty must not be negative",
+		ymlPath + ":16: copy: source does not exist",
+		ymlPath + ":17: copy: invalid pattern",
+		ymlPath + ":19: hooks: invalid template",
+	}
+
+	for _, e := range exp {
+		found := false
+		for _, p := range problems {
+			if strings.HasPrefix(p, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected problem %q, got:\n%v", e, strings.Join(problems, "\n"))
+		}
+	}
+
+	for _, p := range problems {
+		if strings.Contains(p, ":9:") || strings.Contains(p, ":10:") {
+			t.Errorf("add entry without ref or qty should default to qty 1: %v", p)
+		}
+	}
+
+	negative := 0
+	for _, p := range problems {
+		if strings.Contains(p, "qty must not be negative") {
+			negative++
+		}
+	}
+	if negative != 1 {
+		t.Errorf("expected 1 negative qty problem, got %v:\n%v", negative, strings.Join(problems, "\n"))
+	}
+
+	_, err = loadRelScript(ymlPath, "")
+	if err == nil {
+		t.Error("expected loadRelScript to fail on invalid script")
+	}
+}
+
+func TestCheckRelScriptExample(t *testing.T) {
+	problems, err := checkRelScript(filepath.Join("example", "electrical", "pcb-design", "PCA-019.yml"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("example release script has problems:\n%v", strings.Join(problems, "\n"))
+	}
+}
@@ -1,26 +1,227 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"text/template"
 
 	"github.com/otiai10/copy"
+	"github.com/samber/lo"
 )
 
 type relScript struct {
+	Include     []string
 	Description string
 	Remove      []bomLine
 	Add         []bomLine
-	Copy        []string
+	Copy        []copyEntry
 	Hooks       []string
-	Required    []string
+	Required    []requiredEntry
+}
+
+// loadRelScript reads a release script from a YAML file, including any files
+// listed under include. Include paths are resolved relative to the including
+// file first, and then relative to templatesDir. Unknown fields and invalid
+// entries are reported as an error.
+//
+// Included files are merged in order before the including file:
+//   - description: the last non-empty value wins
+//   - remove, add, copy, hooks: lists are concatenated in include order,
+//     followed by the entries of the including file
+//   - required: lists are concatenated, but an entry for the same file
+//     replaces the earlier one
+func loadRelScript(filePath, templatesDir string) (*relScript, error) {
+	l := relScriptLoader{templatesDir: templatesDir}
+	rs, err := l.load(filePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(l.problems) > 0 {
+		return nil, fmt.Errorf("invalid release script:\n  %v",
+			strings.Join(l.problems, "\n  "))
+	}
+
+	return rs, nil
+}
+
+// relScriptLoader loads release scripts and their includes, collecting any
+// problems found while validating them.
+type relScriptLoader struct {
+	templatesDir string
+	// srcDir is the directory copy sources are checked against. Copy
+	// sources are not checked if this is blank as they may be generated
+	// during the release.
+	srcDir   string
+	problems []string
+}
+
+func (l *relScriptLoader) load(filePath string, stack []string) (*relScript, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if lo.Contains(stack, absPath) {
+		return nil, fmt.Errorf("include cycle detected: %v", strings.Join(append(stack, absPath), " -> "))
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, doc, err := l.decode(filePath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	l.check(filePath, doc, rs)
+
+	ret := &relScript{}
+	for _, inc := range rs.Include {
+		if inc == "" {
+			continue
+		}
+
+		incPath, err := resolveInclude(inc, filepath.Dir(filePath), l.templatesDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error in %v: %v", filePath, err)
+		}
+
+		incRs, err := l.load(incPath, append(stack, absPath))
+		if err != nil {
+			return nil, err
+		}
+
+		ret.merge(incRs)
+	}
+
+	ret.merge(rs)
+
+	return ret, nil
+}
+
+// resolveInclude locates an include file relative to dir, and then templatesDir
+func resolveInclude(inc, dir, templatesDir string) (string, error) {
+	if filepath.IsAbs(inc) {
+		return inc, nil
+	}
+
+	candidates := []string{filepath.Join(dir, inc)}
+	if templatesDir != "" {
+		candidates = append(candidates, filepath.Join(templatesDir, inc))
+	}
+
+	for _, c := range candidates {
+		e, err := exists(c)
+		if err != nil {
+			return "", err
+		}
+		if e {
+			return c, nil
+		}
+	}
+
+	return "", fmt.Errorf("include file not found: %v", inc)
+}
+
+// merge appends the contents of o to rs
+func (rs *relScript) merge(o *relScript) {
+	if o.Description != "" {
+		rs.Description = o.Description
+	}
+
+	rs.Remove = append(rs.Remove, o.Remove...)
+	rs.Add = append(rs.Add, o.Add...)
+	rs.Copy = append(rs.Copy, o.Copy...)
+	rs.Hooks = append(rs.Hooks, o.Hooks...)
+
+	for _, r := range o.Required {
+		_, i, found := lo.FindIndexOf(rs.Required, func(e requiredEntry) bool {
+			return e.File == r.File
+		})
+		if found {
+			rs.Required[i] = r
+		} else {
+			rs.Required = append(rs.Required, r)
+		}
+	}
+}
+
+// copyEntry describes something to copy into the release directory. In
+// YAML it can be given as a plain path, or as a map with the following keys:
+//
+//   - src: file, directory, or glob pattern (ex: gerber/*.gbr)
+//   - dest: destination name in the release dir. For glob patterns this is
+//     the directory matches are copied into.
+//   - exclude: glob patterns for files that should not be copied
+//   - from: name of another source directory (located with findDir) to copy
+//     from instead of the release source directory
+//
+// src and dest may contain the same template variables as hooks.
+type copyEntry struct {
+	Src     string   `yaml:"src"`
+	Dest    string   `yaml:"dest"`
+	Exclude []string `yaml:"exclude"`
+	From    string   `yaml:"from"`
+}
+
+func (c *copyEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*c = copyEntry{Src: s}
+		return nil
+	}
+
+	// plain type prevents recursing back into UnmarshalYAML
+	type plain copyEntry
+	return unmarshal((*plain)(c))
+}
+
+// excluded returns true if the path (relative to the copy source dir)
+// matches one of the exclude patterns. Patterns are matched against both
+// the relative path and the file name.
+func (c *copyEntry) excluded(rel string) bool {
+	for _, e := range c.Exclude {
+		if m, _ := filepath.Match(e, rel); m {
+			return true
+		}
+		if m, _ := filepath.Match(e, filepath.Base(rel)); m {
+			return true
+		}
+	}
+	return false
+}
+
+// templateData is the data available to templates in the release script
+type templateData struct {
+	SrcDir string
+	RelDir string
+	IPN    string
+}
+
+func expandTemplate(text string, data templateData) (string, error) {
+	t, err := template.New("relScript").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+
+	err = t.Execute(&out, data)
+	if err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
 }
 
 func (rs *relScript) processBom(b bom) (bom, error) {
@@ -49,11 +250,30 @@ func (rs *relScript) processBom(b bom) (bom, error) {
 	}
 
 	for _, a := range rs.Add {
-		refs := strings.Split(a.Ref, ",")
-		a.Qty = float64(len(refs))
-		if a.Qty < 0 {
+		// an explicit qty is used as is (can be fractional for things
+		// like glue or wire), otherwise qty is the number of refs
+		refs := parseRefs(a.Ref)
+		if a.Qty <= 0 {
+			a.Qty = float64(len(refs))
+		}
+		if a.Qty <= 0 {
 			a.Qty = 1.0
 		}
+		a.Ref = strings.Join(refs, " ")
+
+		// merge with an existing line for the same part
+		if a.IPN != "" {
+			l, found := lo.Find(ret, func(l *bomLine) bool {
+				return l.IPN == a.IPN
+			})
+			if found {
+				l.Qty += a.Qty
+				l.Ref = strings.TrimSpace(l.Ref + " " + a.Ref)
+				l.sortRefs()
+				continue
+			}
+		}
+
 		// for some reason we need to make a copy or it
 		// will alias the last one
 		c := a
@@ -65,54 +285,110 @@ func (rs *relScript) processBom(b bom) (bom, error) {
 	return ret, nil
 }
 
-func (rs *relScript) copy(srcDir, destDir string) error {
+func (rs *relScript) copy(pn string, srcDir, destDir string) error {
+	data := templateData{
+		SrcDir: srcDir,
+		RelDir: destDir,
+		IPN:    pn,
+	}
+
 	for _, c := range rs.Copy {
+		src, err := expandTemplate(c.Src, data)
+		if err != nil {
+			return fmt.Errorf("Error parsing copy src: %v: %v", c.Src, err)
+		}
+
+		dest, err := expandTemplate(c.Dest, data)
+		if err != nil {
+			return fmt.Errorf("Error parsing copy dest: %v: %v", c.Dest, err)
+		}
+
+		baseDir := srcDir
+		if c.From != "" {
+			baseDir, err = findDir(c.From)
+			if err != nil {
+				return fmt.Errorf("Error finding copy source dir: %v", err)
+			}
+		}
+
+		isGlob := strings.ContainsAny(src, "*?[")
+		matches := []string{src}
+		if isGlob {
+			paths, err := filepath.Glob(filepath.Join(baseDir, src))
+			if err != nil {
+				return fmt.Errorf("Error matching copy pattern %v: %v", src, err)
+			}
+			if len(paths) == 0 {
+				return fmt.Errorf("No files match copy pattern: %v", src)
+			}
+			matches = matches[:0]
+			for _, p := range paths {
+				rel, err := filepath.Rel(baseDir, p)
+				if err != nil {
+					return err
+				}
+				matches = append(matches, rel)
+			}
+		}
+
+		// keep modification times so required newerThanBom checks the source
+		// files and not when they were copied
 		opts := copy.Options{
+			PreserveTimes: true,
 			OnSymlink: func(src string) copy.SymlinkAction {
 				return copy.Deep
 			},
 			OnDirExists: func(src, dest string) copy.DirExistsAction {
 				return copy.Replace
 			},
+			Skip: func(_ os.FileInfo, src, _ string) (bool, error) {
+				rel, err := filepath.Rel(baseDir, src)
+				if err != nil {
+					return false, err
+				}
+				return c.excluded(rel), nil
+			},
 		}
-		srcPath := path.Join(srcDir, c)
-		destPath := path.Join(destDir, c)
-		err := copy.Copy(srcPath, destPath, opts)
-		if err != nil {
-			return err
-		}
 
-		log.Printf("%v copied to release dir\n", c)
+		for _, m := range matches {
+			if c.excluded(m) {
+				continue
+			}
+
+			destRel := m
+			if dest != "" {
+				destRel = dest
+				if isGlob {
+					destRel = filepath.Join(dest, filepath.Base(m))
+				}
+			}
+
+			err := copy.Copy(filepath.Join(baseDir, m), filepath.Join(destDir, destRel), opts)
+			if err != nil {
+				return err
+			}
+
+			log.Printf("%v copied to release dir as %v\n", m, destRel)
+		}
 	}
 
 	return nil
 }
 
 func (rs *relScript) hooks(pn string, srcDir, destDir string) error {
-	data := struct {
-		SrcDir string
-		RelDir string
-		IPN    string
-	}{
+	data := templateData{
 		SrcDir: srcDir,
 		RelDir: destDir,
 		IPN:    pn,
 	}
 
 	for _, h := range rs.Hooks {
-		t, err := template.New("hook").Parse(h)
+		out, err := expandTemplate(h, data)
 		if err != nil {
 			return fmt.Errorf("Error parsing hook: %v: %v", h, err)
 		}
 
-		var out strings.Builder
-
-		err = t.Execute(&out, data)
-		if err != nil {
-			return fmt.Errorf("Error parsing hook: %v: %v", h, err)
-		}
-
-		cmd := exec.Command("/bin/sh", "-c", out.String())
+		cmd := exec.Command("/bin/sh", "-c", out)
 
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
@@ -141,24 +417,135 @@ func (rs *relScript) hooks(pn string, srcDir, destDir string) error {
 		if err := cmd.Wait(); err != nil {
 			log.Println("Error running hook: ", err)
 			log.Println("Hook contents: ")
-			fmt.Print(out.String())
+			fmt.Print(out)
 			return err
 		}
 	}
 	return nil
 }
 
-func (rs *relScript) required(destDir string) error {
-	for _, r := range rs.Required {
-		p := path.Join(destDir, r)
+// requiredEntry describes a file that must be present in the release
+// directory. In YAML it can be given as a plain path, or as a map with the
+// following keys:
+//
+//   - file: file name or glob pattern relative to the release dir
+//   - minSize: minimum size in bytes of each matching file
+//   - minCount: minimum number of files the glob must match (default 1)
+//   - newerThanBom: file must be modified after the source BOM
+//   - containsIPN: file must contain the release IPN string
+type requiredEntry struct {
+	File         string `yaml:"file"`
+	MinSize      int64  `yaml:"minSize"`
+	MinCount     int    `yaml:"minCount"`
+	NewerThanBom bool   `yaml:"newerThanBom"`
+	ContainsIPN  bool   `yaml:"containsIPN"`
+}
+
+func (r *requiredEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*r = requiredEntry{File: s}
+		return nil
+	}
+
+	type plain requiredEntry
+	return unmarshal((*plain)(r))
+}
+
+// check returns a list of requirements this entry does not meet
+func (r *requiredEntry) check(pn, destDir string, bomInfo os.FileInfo) ([]string, error) {
+	var problems []string
+
+	minCount := r.MinCount
+	if minCount <= 0 {
+		minCount = 1
+	}
+
+	p := filepath.Join(destDir, r.File)
+	matches := []string{}
+	if strings.ContainsAny(r.File, "*?[") {
+		var err error
+		matches, err = filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("Error matching required pattern: %v: %v", p, err)
+		}
+	} else {
 		e, err := exists(p)
 		if err != nil {
-			return fmt.Errorf("Error looking for required file: %v: %v", p, err)
+			return nil, fmt.Errorf("Error looking for required file: %v: %v", p, err)
+		}
+		if e {
+			matches = append(matches, p)
+		}
+	}
+
+	if len(matches) == 0 {
+		return []string{fmt.Sprintf("Required file does not exist, please generate it: %v", p)}, nil
+	}
+
+	if len(matches) < minCount {
+		problems = append(problems, fmt.Sprintf("Required pattern %v matched %v files, need at least %v",
+			p, len(matches), minCount))
+	}
+
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			return nil, fmt.Errorf("Error looking for required file: %v: %v", m, err)
+		}
+
+		if r.MinSize > 0 && info.Size() < r.MinSize {
+			problems = append(problems, fmt.Sprintf("Required file %v is %v bytes, need at least %v",
+				m, info.Size(), r.MinSize))
+		}
+
+		if r.NewerThanBom {
+			if bomInfo == nil {
+				problems = append(problems, fmt.Sprintf("Required file %v must be newer than the source BOM, but there is no source BOM", m))
+			} else if !info.ModTime().After(bomInfo.ModTime()) {
+				problems = append(problems, fmt.Sprintf("Required file %v is older than the source BOM, please regenerate it", m))
+			}
+		}
+
+		if r.ContainsIPN && !info.IsDir() {
+			data, err := os.ReadFile(m)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading required file: %v: %v", m, err)
+			}
+			if !bytes.Contains(data, []byte(pn)) {
+				problems = append(problems, fmt.Sprintf("Required file %v does not contain IPN %v", m, pn))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// required checks that all required files are present in the release and meet
+// the specified requirements. All unmet requirements are reported in the
+// returned error. bomPath is the source BOM, and may be blank if there is none.
+func (rs *relScript) required(pn, destDir, bomPath string) error {
+	var bomInfo os.FileInfo
+	if bomPath != "" {
+		var err error
+		bomInfo, err = os.Stat(bomPath)
+		if err != nil {
+			return fmt.Errorf("Error reading source BOM: %v", err)
 		}
+	}
 
-		if !e {
-			return fmt.Errorf("Required file does not exist, please generate it: %v", p)
+	var problems []string
+	for _, r := range rs.Required {
+		p, err := r.check(pn, destDir, bomInfo)
+		if err != nil {
+			return err
 		}
+		problems = append(problems, p...)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Required files are missing or invalid:\n  %v",
+			strings.Join(problems, "\n  "))
 	}
 
 	return nil
@@ -2,11 +2,15 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gocarina/gocsv"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 var modFile = `
@@ -69,3 +73,309 @@ func TestRelScript(t *testing.T) {
 		t.Error("bExp not the same as bModified")
 	}
 }
+
+var copyFile = `
+copy:
+ - mfg
+ - src: gerber/*.gbr
+   dest: fab
+   exclude:
+    - "*-old.gbr"
+ - src: fab/out.pdf
+   dest: "{{ .IPN }}-fab.pdf"
+`
+
+func TestRelScriptCopy(t *testing.T) {
+	srcDir := t.TempDir()
+	relDir := t.TempDir()
+
+	files := []string{
+		"mfg/notes.txt",
+		"gerber/top.gbr",
+		"gerber/bottom.gbr",
+		"gerber/top-old.gbr",
+		"gerber/readme.txt",
+		"fab/out.pdf",
+	}
+
+	for _, f := range files {
+		p := filepath.Join(srcDir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs := relScript{}
+	err := yaml.Unmarshal([]byte(copyFile), &rs)
+	if err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	err = rs.copy("PCA-019-0003", srcDir, relDir)
+	if err != nil {
+		t.Fatalf("error copying: %v", err)
+	}
+
+	expExists := []string{
+		"mfg/notes.txt",
+		"fab/top.gbr",
+		"fab/bottom.gbr",
+		"PCA-019-0003-fab.pdf",
+	}
+
+	for _, f := range expExists {
+		if !fileExists(filepath.Join(relDir, f)) {
+			t.Errorf("expected %v to be copied", f)
+		}
+	}
+
+	expMissing := []string{
+		"fab/top-old.gbr",
+		"fab/readme.txt",
+		"gerber",
+		"fab/out.pdf",
+	}
+
+	for _, f := range expMissing {
+		if fileExists(filepath.Join(relDir, f)) {
+			t.Errorf("did not expect %v to be copied", f)
+		}
+	}
+}
+
+var newerThanBomFile = `
+copy:
+ - fab.pdf
+ - gerber.zip
+required:
+ - file: fab.pdf
+   newerThanBom: true
+ - file: gerber.zip
+   newerThanBom: true
+`
+
+func TestRelScriptNewerThanBom(t *testing.T) {
+	srcDir := t.TempDir()
+	relDir := t.TempDir()
+
+	bomPath := filepath.Join(srcDir, "PCA-019.csv")
+	files := map[string]time.Time{
+		"fab.pdf":     time.Now().Add(-2 * time.Hour),
+		"PCA-019.csv": time.Now().Add(-time.Hour),
+		"gerber.zip":  time.Now(),
+	}
+	for f, mtime := range files {
+		p := filepath.Join(srcDir, f)
+		if err := os.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs := relScript{}
+	if err := yaml.Unmarshal([]byte(newerThanBomFile), &rs); err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	if err := rs.copy("PCA-019-0003", srcDir, relDir); err != nil {
+		t.Fatalf("error copying: %v", err)
+	}
+
+	// fab.pdf was generated before the BOM changed, even though it was just
+	// copied
+	err := rs.required("PCA-019-0003", relDir, bomPath)
+	if err == nil || !strings.Contains(err.Error(), "fab.pdf is older than the source BOM") {
+		t.Errorf("expected stale fab.pdf to fail, got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "gerber.zip") {
+		t.Errorf("did not expect error for gerber.zip: %v", err)
+	}
+}
+
+var requiredFile = `
+required:
+ - PCA-019-0003_ibom.html
+ - file: "*.gbr"
+   minCount: 3
+ - file: fab.txt
+   containsIPN: true
+   minSize: 100
+`
+
+func TestRelScriptRequired(t *testing.T) {
+	relDir := t.TempDir()
+
+	files := map[string]string{
+		"PCA-019-0003_ibom.html": "ibom",
+		"top.gbr":                "top",
+		"bottom.gbr":             "bottom",
+		"fab.txt":                "PCA-019-0002",
+	}
+
+	for f, c := range files {
+		if err := os.WriteFile(filepath.Join(relDir, f), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs := relScript{}
+	err := yaml.Unmarshal([]byte(requiredFile), &rs)
+	if err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	err = rs.required("PCA-019-0003", relDir, "")
+	if err == nil {
+		t.Fatal("expected required check to fail")
+	}
+
+	// all unmet requirements should be reported at once
+	for _, exp := range []string{"matched 2 files", "does not contain IPN", "bytes, need at least 100"} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("expected error to contain %q, got: %v", exp, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "ibom") {
+		t.Errorf("did not expect error for ibom file: %v", err)
+	}
+}
+
+func TestRelScriptInclude(t *testing.T) {
+	srcDir := t.TempDir()
+	templatesDir := t.TempDir()
+
+	files := map[string]string{
+		filepath.Join(templatesDir, "std.yml"): `
+description: company standard
+hooks:
+ - echo std
+copy:
+ - MFG.md
+required:
+ - file: fab.pdf
+   minSize: 10
+`,
+		filepath.Join(srcDir, "local.yml"): `
+include:
+ - std.yml
+copy:
+ - gerber
+`,
+		filepath.Join(srcDir, "PCA-019.yml"): `
+include:
+ - local.yml
+hooks:
+ - echo local
+required:
+ - fab.pdf
+ - ibom.html
+`,
+	}
+
+	for f, c := range files {
+		if err := os.WriteFile(f, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs, err := loadRelScript(filepath.Join(srcDir, "PCA-019.yml"), templatesDir)
+	if err != nil {
+		t.Fatalf("error loading rel script: %v", err)
+	}
+
+	if rs.Description != "company standard" {
+		t.Errorf("wrong description: %v", rs.Description)
+	}
+
+	if !reflect.DeepEqual(rs.Hooks, []string{"echo std", "echo local"}) {
+		t.Errorf("wrong hooks: %v", rs.Hooks)
+	}
+
+	if len(rs.Copy) != 2 || rs.Copy[0].Src != "MFG.md" || rs.Copy[1].Src != "gerber" {
+		t.Errorf("wrong copy: %v", rs.Copy)
+	}
+
+	expRequired := []requiredEntry{{File: "fab.pdf"}, {File: "ibom.html"}}
+	if !reflect.DeepEqual(rs.Required, expRequired) {
+		t.Errorf("wrong required: %v", rs.Required)
+	}
+
+	// include cycles are an error
+	err = os.WriteFile(filepath.Join(srcDir, "local.yml"), []byte("include:\n - PCA-019.yml\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadRelScript(filepath.Join(srcDir, "PCA-019.yml"), templatesDir)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected include cycle error, got: %v", err)
+	}
+}
+
+var qtyFile = `
+remove:
+ - ref: R1, D13
+add:
+ - cmpName: glue
+   ipn: MCH-001-0001
+   qty: 0.25
+ - cmpName: diode
+   ref: D20,D15
+   ipn: DIO-023-0023
+ - cmpName: screw
+   ref: S1 S2
+   qty: 4
+   ipn: SCR-002-0002
+`
+
+var qtyBomIn = `
+Ref,Qty,Value,Cmp name,Footprint,Description,Vendor,IPN,Datasheet
+R1 R2,2,,100K_100mw,,,,RES-006-0232,
+D1 D2 D13 D14,4,,diode,,,,DIO-023-0023,
+,1.5,,wire,,,,CBL-001-0001,
+`
+
+var qtyBomExp = `
+Ref,Qty,Value,Cmp name,Footprint,Description,Vendor,IPN,Datasheet
+,1.5,,wire,,,,CBL-001-0001,
+D1 D2 D14 D15 D20,5,,diode,,,,DIO-023-0023,
+,0.25,,glue,,,,MCH-001-0001,
+R2,1,,100K_100mw,,,,RES-006-0232,
+S1 S2,4,,screw,,,,SCR-002-0002,
+`
+
+func TestRelScriptQty(t *testing.T) {
+	initCSV()
+	bIn := bom{}
+	err := gocsv.UnmarshalBytes([]byte(qtyBomIn), &bIn)
+	if err != nil {
+		t.Fatalf("error parsing bomIn: %v", err)
+	}
+
+	bExp := bom{}
+	err = gocsv.UnmarshalBytes([]byte(qtyBomExp), &bExp)
+	if err != nil {
+		t.Fatalf("error parsing bomExp: %v", err)
+	}
+
+	rs := relScript{}
+	err = yaml.Unmarshal([]byte(qtyFile), &rs)
+	if err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	bModified, err := rs.processBom(bIn)
+	if err != nil {
+		t.Fatalf("error processing bom: %v", err)
+	}
+
+	if !reflect.DeepEqual(bExp, bModified) {
+		t.Errorf("bExp not the same as bModified:\nexp: %v\ngot: %v", bExp, bModified)
+	}
+}
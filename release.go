@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -9,11 +10,9 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
-
-	"gopkg.in/yaml.v2"
 )
 
-func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string, error) {
+func processRelease(relPn string, relLog *strings.Builder, config *Config) (string, error) {
 	c, n, v, err := ipn(relPn).parse()
 	if err != nil {
 		return "", fmt.Errorf("error parsing bom %v IPN : %v", relPn, err)
@@ -27,36 +26,41 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 	bomFileGenerated := relPn + ".csv"
 	ymlFile := relPnBase + ".yml"
 	ymlFileWithVar := relPnBaseWithVar + ".yml"
-	bomExists := false
-	ymlExists := false
 	sourceDir := ""
 
+	// findFirst returns the path of the first source file found, or "" if
+	// none of the files exist
+	findFirst := func(names ...string) (string, error) {
+		for _, name := range names {
+			p, err := findSourceFile(name)
+			if err == nil {
+				return p, nil
+			}
+			if isAmbiguous(err) {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+
 	// Try to find BOM file - first try CCC-NNN.csv, then CCC-NNN-VV.csv
-	bomFilePath, err := findFile(bomFile)
-	if err == nil {
-		bomExists = true
+	bomFilePath, err := findFirst(bomFile, bomFileWithVar)
+	if err != nil {
+		return "", err
+	}
+	bomExists := bomFilePath != ""
+	if bomExists {
 		sourceDir = filepath.Dir(bomFilePath)
-	} else {
-		// Try with variation pattern
-		bomFilePath, err = findFile(bomFileWithVar)
-		if err == nil {
-			bomExists = true
-			sourceDir = filepath.Dir(bomFilePath)
-		}
 	}
 
 	// Try to find YML file - first try CCC-NNN.yml, then CCC-NNN-VV.yml
-	ymlFilePath, err := findFile(ymlFile)
-	if err == nil {
-		ymlExists = true
+	ymlFilePath, err := findFirst(ymlFile, ymlFileWithVar)
+	if err != nil {
+		return "", err
+	}
+	ymlExists := ymlFilePath != ""
+	if ymlExists {
 		sourceDir = filepath.Dir(ymlFilePath)
-	} else {
-		// Try with variation pattern
-		ymlFilePath, err = findFile(ymlFileWithVar)
-		if err == nil {
-			ymlExists = true
-			sourceDir = filepath.Dir(ymlFilePath)
-		}
 	}
 
 	if !ymlExists && !bomExists {
@@ -72,6 +76,20 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 		}
 	}
 
+	// check release policy, in order so the same missing file is reported
+	policyFiles := []struct {
+		file     string
+		required bool
+	}{
+		{"CHANGELOG.md", config.Release.RequireChangelog},
+		{"MFG.md", config.Release.RequireMfg},
+	}
+	for _, p := range policyFiles {
+		if p.required && !fileExists(filepath.Join(sourceDir, p.file)) {
+			return "", fmt.Errorf("Release policy requires %v in source dir %v", p.file, sourceDir)
+		}
+	}
+
 	// Create output release dir
 	releaseDir := filepath.Join(sourceDir, relPn)
 
@@ -87,7 +105,7 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 		}
 	}
 
-	bomFileWritePath := filepath.Join(releaseDir, bomFileGenerated)
+	bomFileWritePath := filepath.Join(releaseDir, relPn)
 
 	logErr := func(s string) {
 		_, err := relLog.Write([]byte(s))
@@ -98,13 +116,16 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 	}
 
 	p := partmaster{}
-	if pmDir != "" {
-		p, err = loadPartmasterFromDir(pmDir)
+	if config.PMDir != "" {
+		p, err = loadPartmasterFromDir(config.PMDir)
 		if err != nil {
-			return sourceDir, fmt.Errorf("Error loading partmaster from directory %s: %v", pmDir, err)
+			return sourceDir, fmt.Errorf("Error loading partmaster from directory %s: %v", config.PMDir, err)
 		}
 	} else {
-		partmasterPath, err := findFile("partmaster.csv")
+		partmasterPath, err := findSourceFile("partmaster.csv")
+		if isAmbiguous(err) {
+			return sourceDir, err
+		}
 		if err != nil {
 			return sourceDir, fmt.Errorf("Error, partmaster.csv not found in any dir")
 		}
@@ -125,17 +146,11 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 	}
 
 	if ymlExists {
-		ymlBytes, err := os.ReadFile(ymlFilePath)
+		rs, err := loadRelScript(ymlFilePath, config.TemplatesDir)
 		if err != nil {
 			return sourceDir, fmt.Errorf("Error loading yml file: %v", err)
 		}
 
-		rs := relScript{}
-		err = yaml.Unmarshal(ymlBytes, &rs)
-		if err != nil {
-			return sourceDir, fmt.Errorf("Error parsing yml: %v", err)
-		}
-
 		if bomExists {
 			b, err = rs.processBom(b)
 			if err != nil {
@@ -149,9 +164,17 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 			return sourceDir, fmt.Errorf("Error running hooks specified in YML: %v", err)
 		}
 
+		// hooks may have generated files
+		if len(rs.Hooks) > 0 {
+			invalidateWorkspace()
+		}
+
 		// look if we generated a BOM
 		if !bomExists {
-			bomFilePath, err := findFile(bomFileGenerated)
+			bomFilePath, err = findBomFile(bomFileGenerated)
+			if isAmbiguous(err) {
+				return sourceDir, err
+			}
 			if err == nil {
 				bomExists = true
 				err = loadCSV(bomFilePath, &b)
@@ -167,13 +190,13 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 		}
 
 		// copy stuff to release dir specified in YML file
-		err = rs.copy(sourceDir, releaseDir)
+		err = rs.copy(relPn, sourceDir, releaseDir)
 		if err != nil {
 			return sourceDir, fmt.Errorf("Error copying files specified in YML: %v", err)
 		}
 
 		// check if required files are present in release
-		err = rs.required(releaseDir)
+		err = rs.required(relPn, releaseDir, bomFilePath)
 		if err != nil {
 			return sourceDir, err
 		}
@@ -188,9 +211,9 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 	sort.Sort(b)
 
 	// merge in partmaster info into BOM
-	b.mergePartmaster(p, logErr)
+	b.mergePartmaster(p, config.Release.PartmasterColumns, logErr)
 
-	err = saveCSV(bomFileWritePath, b)
+	err = saveReleaseBom(bomFileWritePath, b, config.Release.OutputFormats)
 	if err != nil {
 		return sourceDir, fmt.Errorf("Error writing BOM: %v", err)
 	}
@@ -258,12 +281,12 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 
 	if foundSub {
 		// merge in partmaster info into BOM
-		b.mergePartmaster(p, logErr)
+		b.mergePartmaster(p, config.Release.PartmasterColumns, logErr)
 		// write out combined BOM
 		sort.Sort(b)
-		writePath := filepath.Join(releaseDir, relPn+"-all.csv")
+		writePath := filepath.Join(releaseDir, relPn+"-all")
 		// write out purchase bom
-		err := saveCSV(writePath, b)
+		err := saveReleaseBom(writePath, b, config.Release.OutputFormats)
 		if err != nil {
 			return sourceDir, fmt.Errorf("Error writing purchase bom %v", err)
 		}
@@ -271,3 +294,35 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 
 	return sourceDir, nil
 }
+
+// saveReleaseBom writes a BOM in each of the output formats. The extension for
+// the format is added to basePath. If no formats are given, the BOM is written
+// as CSV.
+func saveReleaseBom(basePath string, b bom, formats []string) error {
+	if len(formats) == 0 {
+		formats = []string{"csv"}
+	}
+
+	for _, f := range formats {
+		switch f {
+		case "csv":
+			err := saveCSV(basePath+".csv", b)
+			if err != nil {
+				return err
+			}
+		case "json":
+			data, err := json.MarshalIndent(b, "", "  ")
+			if err != nil {
+				return err
+			}
+			err = os.WriteFile(basePath+".json", append(data, '\n'), 0644)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown output format: %v", f)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReleasePolicy(t *testing.T) {
+	root := t.TempDir()
+	configureWorkspace(root, nil)
+	defer configureWorkspace(".", nil)
+
+	srcDir := filepath.Join(root, "pcb")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "PCA-001.csv"), []byte("Ref,Qty,IPN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{Release: ReleaseConfig{RequireChangelog: true, RequireMfg: true}}
+
+	// files are checked in order, so CHANGELOG.md is always reported first
+	for _, missing := range []string{"CHANGELOG.md", "MFG.md"} {
+		for i := 0; i < 20; i++ {
+			_, err := processRelease("PCA-001-0001", &strings.Builder{}, config)
+			if err == nil || !strings.Contains(err.Error(), "requires "+missing) {
+				t.Fatalf("expected %v to be required, got %v", missing, err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, missing), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
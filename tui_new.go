@@ -103,9 +103,16 @@ type modelNew struct {
 	csvCollection *CSVFileCollection
 	selectedFile  string
 	listFocused   bool
+	config        *Config
+	cache         *partCache
+	searchInput   textinput.Model
+	searching     bool
+	query         string
 }
 
-func initialModelNew(needsPMDir bool, pmDir string) modelNew {
+func initialModelNew(needsPMDir bool, config *Config) modelNew {
+	pmDir := config.PMDir
+
 	ti := textinput.New()
 	ti.Placeholder = "/path/to/partmaster/directory"
 	ti.Focus()
@@ -144,7 +151,15 @@ func initialModelNew(needsPMDir bool, pmDir string) modelNew {
 		Bold(false)
 	t.SetStyles(s)
 
+	si := textinput.New()
+	si.Prompt = "/"
+	si.Placeholder = "IPN, MPN, or description"
+	si.CharLimit = 256
+	si.Width = 50
+
 	m := modelNew{
+		config:      config,
+		searchInput: si,
 		textInput:   ti,
 		fileList:    l,
 		table:       t,
@@ -176,6 +191,18 @@ func (m *modelNew) loadCSVFiles() {
 
 	m.csvCollection = collection
 
+	if m.cache != nil {
+		err = m.cache.sync(collection)
+	} else {
+		// the directory may have been entered in the TUI
+		config := *m.config
+		config.PMDir = m.pmDir
+		m.cache, err = loadPartCache(&config, collection)
+	}
+	if err != nil {
+		m.error = "Error loading cache: " + err.Error()
+	}
+
 	// Update file list
 	items := []list.Item{
 		fileItem{name: allFilesOption, isAllOption: true},
@@ -202,7 +229,56 @@ func (m *modelNew) updateTableForSelectedFile() {
 		return
 	}
 
-	if m.selectedFile == allFilesOption {
+	// rows matching the search, by file name and row index
+	var matches map[string]map[int]bool
+	var refs []partRef
+	if m.query != "" {
+		var err error
+		refs, err = findParts(m.csvCollection, m.cache, m.query)
+		if err != nil {
+			m.error = "Error searching: " + err.Error()
+			return
+		}
+		matches = make(map[string]map[int]bool)
+		for _, r := range refs {
+			if matches[r.File] == nil {
+				matches[r.File] = make(map[int]bool)
+			}
+			matches[r.File][r.Row] = true
+		}
+	}
+
+	if m.selectedFile == allFilesOption && m.query != "" {
+		// Show search results from all files
+		m.table.SetRows([]table.Row{})
+		m.table.SetColumns([]table.Column{
+			{Title: "IPN", Width: 15},
+			{Title: "Description", Width: 30},
+			{Title: "Manufacturer", Width: 20},
+			{Title: "MPN", Width: 20},
+			{Title: "Value", Width: 10},
+		})
+
+		rows := []table.Row{}
+		for _, r := range refs {
+			file := m.csvCollection.file(r.File)
+			if file == nil || r.Row >= len(file.Rows) {
+				continue
+			}
+			row := file.Rows[r.Row]
+			tableRow := table.Row{}
+			for _, c := range []string{"IPN", "Description", "Manufacturer", "MPN", "Value"} {
+				v := ""
+				if i := findColumn(file.Headers, c); i >= 0 && i < len(row) {
+					v = row[i]
+				}
+				tableRow = append(tableRow, v)
+			}
+			rows = append(rows, tableRow)
+		}
+		m.table.SetRows(rows)
+		m.table.SetCursor(0)
+	} else if m.selectedFile == allFilesOption {
 		// Show combined partmaster view
 		pm, err := m.csvCollection.GetCombinedPartmaster()
 		if err != nil {
@@ -278,11 +354,16 @@ func (m *modelNew) updateTableForSelectedFile() {
 			}
 			// Update rows first, ensuring they match column count
 			rows := []table.Row{}
-			for _, row := range csvFile.Rows {
+			for rowIdx, row := range csvFile.Rows {
 				// Skip completely empty rows
 				if len(row) == 0 {
 					continue
 				}
+
+				// Skip rows that do not match the search
+				if matches != nil && !matches[csvFile.Name][rowIdx] {
+					continue
+				}
 				
 				// Ensure row has correct number of columns
 				tableRow := make([]string, len(columns))
@@ -392,10 +473,39 @@ func (m modelNew) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.loadCSVFiles()
 				return m, nil
 			}
+		} else if m.searching {
+			switch msg.String() {
+			case "ctrl+c":
+				return m, tea.Quit
+			case "enter":
+				m.searching = false
+				m.searchInput.Blur()
+				m.query = strings.TrimSpace(m.searchInput.Value())
+				m.updateTableForSelectedFile()
+				return m, nil
+			case "esc":
+				m.searching = false
+				m.searchInput.Blur()
+				m.searchInput.SetValue(m.query)
+				return m, nil
+			}
+			m.searchInput, cmd = m.searchInput.Update(msg)
+			return m, cmd
 		} else {
 			switch msg.String() {
 			case "ctrl+c", "q":
 				return m, tea.Quit
+			case "/":
+				m.searching = true
+				m.searchInput.Focus()
+				return m, textinput.Blink
+			case "esc":
+				if m.query != "" {
+					m.query = ""
+					m.searchInput.SetValue("")
+					m.updateTableForSelectedFile()
+				}
+				return m, nil
 			case "tab":
 				// Toggle focus between list and table
 				m.listFocused = !m.listFocused
@@ -518,7 +628,13 @@ func (m modelNew) View() string {
 		// Join list and table horizontally
 		mainContent := lipgloss.JoinHorizontal(lipgloss.Top, listView, tableView)
 
-		help := helpStyle2.Width(m.width).Render("Press Tab to switch focus • ↑/↓ to navigate • Enter to select • q or Ctrl+C to quit")
+		help := helpStyle2.Width(m.width).Render("Press Tab to switch focus • ↑/↓ to navigate • Enter to select • / to search • Esc to clear search • q or Ctrl+C to quit")
+
+		// Show search input while searching, or the active search
+		var search string
+		if m.searching || m.query != "" {
+			search = m.searchInput.View()
+		}
 
 		// Join all components
 		components := []string{title, subtitle}
@@ -529,6 +645,9 @@ func (m modelNew) View() string {
 		if errorMsg != "" {
 			components = append(components, errorMsg)
 		}
+		if search != "" {
+			components = append(components, search)
+		}
 
 		components = append(components, mainContent, help)
 		content := lipgloss.JoinVertical(lipgloss.Top, components...)
@@ -537,9 +656,13 @@ func (m modelNew) View() string {
 	}
 }
 
-func runTUINew(pmDir string) error {
-	needsPMDir := pmDir == ""
-	p := tea.NewProgram(initialModelNew(needsPMDir, pmDir), tea.WithAltScreen())
-	_, err := p.Run()
+func runTUINew(config *Config) error {
+	needsPMDir := config.PMDir == ""
+	m := initialModelNew(needsPMDir, config)
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	final, err := p.Run()
+	if fm, ok := final.(modelNew); ok && fm.cache != nil {
+		fm.cache.close()
+	}
 	return err
 }
\ No newline at end of file
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ignoreFiles are read in every directory of the workspace
+var ignoreFiles = []string{".gitignore", ".gitplmignore"}
+
+// workspace is an index of the source and release files in a directory tree.
+// The tree is walked once when the index is built so that lookups do not need
+// to walk the tree again. Files and directories matched by .gitignore or
+// .gitplmignore files are not indexed.
+type workspace struct {
+	root string
+	// sourceRoots limits source file lookups to these directories
+	// (relative to root). All directories are searched if empty.
+	sourceRoots []string
+	files       map[string][]string
+	dirs        map[string][]string
+}
+
+// newWorkspace builds the index for the tree at root. Soft links are not
+// followed.
+func newWorkspace(root string, sourceRoots []string) (*workspace, error) {
+	ws := &workspace{
+		root:        root,
+		sourceRoots: sourceRoots,
+		files:       make(map[string][]string),
+		dirs:        make(map[string][]string),
+	}
+
+	var rules ignoreRules
+
+	err := fs.WalkDir(os.DirFS(root), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if p != "." {
+				if d.Name() == ".git" || rules.ignored(p, true) {
+					return fs.SkipDir
+				}
+				ws.dirs[d.Name()] = append(ws.dirs[d.Name()], ws.path(p))
+			}
+
+			for _, f := range ignoreFiles {
+				r, err := loadIgnoreFile(filepath.Join(root, p, f), p)
+				if err != nil {
+					return fmt.Errorf("error reading %v: %v", path.Join(p, f), err)
+				}
+				rules = append(rules, r...)
+			}
+
+			return nil
+		}
+
+		if rules.ignored(p, false) {
+			return nil
+		}
+
+		ws.files[d.Name()] = append(ws.files[d.Name()], ws.path(p))
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// path converts a path relative to the workspace root to a path that can be
+// used to access the file
+func (ws *workspace) path(rel string) string {
+	return filepath.Join(ws.root, filepath.FromSlash(rel))
+}
+
+// findFile returns the path to a file with the given name. It is an error if
+// more than one file has the name.
+func (ws *workspace) findFile(name string) (string, error) {
+	return unique("File", name, ws.files[name])
+}
+
+// findSourceFile returns the path to a source file (BOM, release script, etc)
+// with the given name. Files in release directories are skipped, and if
+// source roots are configured, only files in those directories are
+// considered. It is an error if more than one file has the name.
+func (ws *workspace) findSourceFile(name string) (string, error) {
+	var paths []string
+	for _, p := range ws.files[name] {
+		if ws.inReleaseDir(p) || !ws.inSourceRoot(p) {
+			continue
+		}
+		paths = append(paths, p)
+	}
+
+	return unique("Source file", name, paths)
+}
+
+// findBomFile returns the path to a BOM for a full IPN, ex: PCA-019-0001.csv.
+// A BOM generated in a source directory is preferred, so the copy written to
+// the release directory by an earlier release is not ambiguous with it.
+// Otherwise, the BOM in a release directory is used, as for sub-assemblies
+// that have been released.
+func (ws *workspace) findBomFile(name string) (string, error) {
+	p, err := ws.findSourceFile(name)
+	if err == nil || isAmbiguous(err) {
+		return p, err
+	}
+
+	var paths []string
+	for _, p := range ws.files[name] {
+		if ws.inReleaseDir(p) {
+			paths = append(paths, p)
+		}
+	}
+	return unique("BOM", name, paths)
+}
+
+// reSourceBom matches the names of source BOMs and release scripts, ex:
+// PCA-019.csv, PCA-019-01.yml
+var reSourceBom = regexp.MustCompile(`^[A-Z][A-Z][A-Z]-\d\d\d(-\d\d)?\.(csv|yml)$`)
+
+// sourceBoms returns the paths of all source BOMs and release scripts in the
+// workspace, sorted by path
+func (ws *workspace) sourceBoms() []string {
+	var paths []string
+	for name, ps := range ws.files {
+		if !reSourceBom.MatchString(name) {
+			continue
+		}
+		for _, p := range ps {
+			if !ws.inReleaseDir(p) && ws.inSourceRoot(p) {
+				paths = append(paths, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// findDir returns the path to a directory with the given name. It is an
+// error if more than one directory has the name.
+func (ws *workspace) findDir(name string) (string, error) {
+	return unique("Dir", name, ws.dirs[name])
+}
+
+// inReleaseDir returns true if the path is inside a release directory
+func (ws *workspace) inReleaseDir(p string) bool {
+	rel, err := filepath.Rel(ws.root, filepath.Dir(p))
+	if err != nil {
+		return false
+	}
+
+	for _, d := range strings.Split(filepath.ToSlash(rel), "/") {
+		if reIpn.MatchString(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inSourceRoot returns true if the path is in one of the configured source
+// roots, or if no source roots are configured
+func (ws *workspace) inSourceRoot(p string) bool {
+	if len(ws.sourceRoots) == 0 {
+		return true
+	}
+
+	for _, r := range ws.sourceRoots {
+		rel, err := filepath.Rel(ws.path(r), p)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ambiguousError is returned when a name matches more than one path
+type ambiguousError struct {
+	kind  string
+	name  string
+	paths []string
+}
+
+func (e *ambiguousError) Error() string {
+	return fmt.Sprintf("%v %v found in multiple locations, remove the extra copies or set sourceRoots in the config:\n  %v",
+		e.kind, e.name, strings.Join(e.paths, "\n  "))
+}
+
+// isAmbiguous returns true if err is caused by a name matching multiple paths
+func isAmbiguous(err error) bool {
+	var e *ambiguousError
+	return errors.As(err, &e)
+}
+
+// unique returns the only path in paths, or an error listing all candidates
+// if the name is ambiguous
+func unique(kind, name string, paths []string) (string, error) {
+	switch len(paths) {
+	case 0:
+		return "", fmt.Errorf("%v not found: %v", kind, name)
+	case 1:
+		return paths[0], nil
+	default:
+		return "", &ambiguousError{kind: kind, name: name, paths: paths}
+	}
+}
+
+// findWorkspaceRoot walks up from the current directory to find the workspace
+// root. This is the first directory containing a .git directory. Outside of a
+// git repo, it is the topmost directory containing a gitplm config file.
+// Config files in the home directory do not mark a workspace root. If no root
+// is found, the current directory is used. The returned path is relative to
+// the current directory.
+func findWorkspaceRoot() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	root := cwd
+	foundConfig := false
+
+	for d := cwd; ; d = filepath.Dir(d) {
+		e, err := exists(filepath.Join(d, ".git"))
+		if err != nil {
+			return "", err
+		}
+		if e {
+			return filepath.Rel(cwd, d)
+		}
+
+		if d != homeDir {
+			for _, n := range configFileNames {
+				e, err := exists(filepath.Join(d, n))
+				if err != nil {
+					return "", err
+				}
+				if e {
+					root = d
+					foundConfig = true
+				}
+			}
+		}
+
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if !foundConfig {
+		return ".", nil
+	}
+
+	return filepath.Rel(cwd, root)
+}
+
+var (
+	currentWorkspace   *workspace
+	currentWorkspaceMu sync.Mutex
+	// workspaceRoot and workspaceSourceRoots are used when building the
+	// current workspace
+	workspaceRoot        = "."
+	workspaceSourceRoots []string
+)
+
+// configureWorkspace sets the root and source roots used for the current
+// workspace
+func configureWorkspace(root string, sourceRoots []string) {
+	currentWorkspaceMu.Lock()
+	defer currentWorkspaceMu.Unlock()
+	workspaceRoot = root
+	workspaceSourceRoots = sourceRoots
+	currentWorkspace = nil
+}
+
+// getWorkspaceRoot returns the root of the current workspace
+func getWorkspaceRoot() string {
+	currentWorkspaceMu.Lock()
+	defer currentWorkspaceMu.Unlock()
+	return workspaceRoot
+}
+
+// getWorkspace returns the index of the current workspace, building it on
+// first use
+func getWorkspace() (*workspace, error) {
+	currentWorkspaceMu.Lock()
+	defer currentWorkspaceMu.Unlock()
+
+	if currentWorkspace == nil {
+		ws, err := newWorkspace(workspaceRoot, workspaceSourceRoots)
+		if err != nil {
+			return nil, err
+		}
+		currentWorkspace = ws
+	}
+
+	return currentWorkspace, nil
+}
+
+// invalidateWorkspace discards the index so that it is rebuilt on the next
+// lookup. This must be called after creating files that later lookups depend
+// on, such as files generated by release hooks.
+func invalidateWorkspace() {
+	currentWorkspaceMu.Lock()
+	defer currentWorkspaceMu.Unlock()
+	currentWorkspace = nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		".gitignore":                        "node_modules/\n*.tmp\n!keep.tmp\n",
+		".gitplmignore":                     "/cad/output\n",
+		"pcb/PCA-019.csv":                   "",
+		"pcb/PCA-019-0001/PCA-019-0001.csv": "",
+		"pcb/scratch.tmp":                   "",
+		"pcb/keep.tmp":                      "",
+		"node_modules/pkg/PCA-019.csv":      "",
+		"cad/output/PCA-020.csv":            "",
+		"cad/PCA-021.csv":                   "",
+		"asy/.gitignore":                    "old/\n",
+		"asy/old/ASY-001.csv":               "",
+		"asy/ASY-001.yml":                   "",
+		"asy/ASY-001-0002/ASY-001-0002.csv": "",
+		"other/old/ASY-002.csv":             "",
+	}
+
+	for f, c := range files {
+		p := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws, err := newWorkspace(root, nil)
+	if err != nil {
+		t.Fatalf("error building workspace: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		dir  bool
+		exp  string
+	}{
+		{"PCA-019.csv", false, "pcb/PCA-019.csv"},
+		{"PCA-019-0001", true, "pcb/PCA-019-0001"},
+		{"PCA-021.csv", false, "cad/PCA-021.csv"},
+		{"keep.tmp", false, "pcb/keep.tmp"},
+		{"ASY-001.yml", false, "asy/ASY-001.yml"},
+		{"ASY-002.csv", false, "other/old/ASY-002.csv"},
+		{"PCA-020.csv", false, ""},
+		{"scratch.tmp", false, ""},
+		{"ASY-001.csv", false, ""},
+		{"node_modules", true, ""},
+	}
+
+	for _, test := range tests {
+		var got string
+		var err error
+		if test.dir {
+			got, err = ws.findDir(test.name)
+		} else {
+			got, err = ws.findFile(test.name)
+		}
+
+		if test.exp == "" {
+			if err == nil {
+				t.Errorf("%v: expected not found, got %v", test.name, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		if got != filepath.Join(root, test.exp) {
+			t.Errorf("%v: exp %v, got %v", test.name, test.exp, got)
+		}
+	}
+}
+
+func TestWorkspaceFindBomFile(t *testing.T) {
+	root := t.TempDir()
+
+	files := []string{
+		// generated by a hook, and copied to the release directory
+		"pcb/PCA-019-0001.csv",
+		"pcb/PCA-019-0001/PCA-019-0001.csv",
+		// a released sub-assembly
+		"asy/ASY-001-0002/ASY-001-0002.csv",
+	}
+
+	for _, f := range files {
+		p := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws, err := newWorkspace(root, nil)
+	if err != nil {
+		t.Fatalf("error building workspace: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		exp  string
+	}{
+		{"PCA-019-0001.csv", "pcb/PCA-019-0001.csv"},
+		{"ASY-001-0002.csv", "asy/ASY-001-0002/ASY-001-0002.csv"},
+		{"ASY-001-0003.csv", ""},
+	}
+
+	for _, test := range tests {
+		got, err := ws.findBomFile(test.name)
+		if test.exp == "" {
+			if err == nil {
+				t.Errorf("%v: expected not found, got %v", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		} else if got != filepath.Join(root, test.exp) {
+			t.Errorf("%v: exp %v, got %v", test.name, test.exp, got)
+		}
+	}
+}
+
+func TestWorkspaceAmbiguous(t *testing.T) {
+	root := t.TempDir()
+
+	files := []string{
+		"a/PCA-019.csv",
+		"b/PCA-019.csv",
+		"a/PCA-019-0001/ASY-002.csv",
+		"c/ASY-002.csv",
+	}
+
+	for _, f := range files {
+		p := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws, err := newWorkspace(root, nil)
+	if err != nil {
+		t.Fatalf("error building workspace: %v", err)
+	}
+
+	_, err = ws.findSourceFile("PCA-019.csv")
+	if !isAmbiguous(err) {
+		t.Errorf("expected ambiguous error, got %v", err)
+	} else if !strings.Contains(err.Error(), filepath.Join(root, "a/PCA-019.csv")) ||
+		!strings.Contains(err.Error(), filepath.Join(root, "b/PCA-019.csv")) {
+		t.Errorf("error does not list all candidates: %v", err)
+	}
+
+	// copies in release dirs are not source files
+	p, err := ws.findSourceFile("ASY-002.csv")
+	if err != nil || p != filepath.Join(root, "c/ASY-002.csv") {
+		t.Errorf("expected source file in c, got %v, %v", p, err)
+	}
+
+	_, err = ws.findFile("ASY-002.csv")
+	if !isAmbiguous(err) {
+		t.Errorf("expected ambiguous error, got %v", err)
+	}
+
+	// source roots can be pinned to resolve ambiguity
+	ws, err = newWorkspace(root, []string{"b"})
+	if err != nil {
+		t.Fatalf("error building workspace: %v", err)
+	}
+
+	p, err = ws.findSourceFile("PCA-019.csv")
+	if err != nil || p != filepath.Join(root, "b/PCA-019.csv") {
+		t.Errorf("expected source file in b, got %v, %v", p, err)
+	}
+}
+
+func TestFindWorkspaceRoot(t *testing.T) {
+	root := t.TempDir()
+
+	sub := filepath.Join(root, "electrical", "pcb-design")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := os.WriteFile(filepath.Join(root, "gitplm.yml"), []byte("pmDir: parts\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := findWorkspaceRoot()
+	if err != nil {
+		t.Fatalf("error finding workspace root: %v", err)
+	}
+
+	if r != filepath.Join("..", "..") {
+		t.Errorf("wrong workspace root: %v", r)
+	}
+
+	config, err := loadConfig(r)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	if config.PMDir != filepath.Join("..", "..", "parts") {
+		t.Errorf("pmDir not resolved relative to workspace root: %v", config.PMDir)
+	}
+}